@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"shufflr/internal/storage"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const usage = `Usage:
+  shufflr                                 Start the server
+  shufflr admin reset-password <user>     Set a new password for an admin account
+  shufflr admin reset-2fa <user>          Turn off two-factor authentication for an admin account
+
+Commands use the database at DATABASE_PATH (default ./shufflr.db) and can run
+while the server is stopped.
+`
+
+// runCommand dispatches command-line subcommands.
+func runCommand(args []string) error {
+	if len(args) >= 2 && args[0] == "admin" && args[1] == "reset-password" {
+		if len(args) != 3 {
+			return errors.New("usage: shufflr admin reset-password <user>")
+		}
+		return resetAdminPassword(args[2], os.Stdin, os.Stdout)
+	}
+	if len(args) >= 2 && args[0] == "admin" && args[1] == "reset-2fa" {
+		if len(args) != 3 {
+			return errors.New("usage: shufflr admin reset-2fa <user>")
+		}
+		return resetAdminTwoFactor(args[2], os.Stdout)
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command: %s", strings.Join(args, " "))
+}
+
+// resetAdminPassword reads a new password from in and sets it for username,
+// signing out all of that user's sessions. When in is a terminal the password
+// is asked for twice and not echoed.
+func resetAdminPassword(username string, in *os.File, out io.Writer) error {
+	db, err := storage.NewDB(getEnv("DATABASE_PATH", "./shufflr.db"))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	user, err := db.GetAdminUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("admin user %q not found", username)
+	}
+
+	interactive := term.IsTerminal(int(in.Fd()))
+
+	reader := bufio.NewReader(in)
+	readPassword := func(prompt string) (string, error) {
+		if !interactive {
+			return readLine(reader)
+		}
+		// Read without echoing the password to the terminal
+		fmt.Fprint(out, prompt)
+		password, err := term.ReadPassword(int(in.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return string(password), nil
+	}
+
+	password, err := readPassword("New password: ")
+	if err != nil {
+		return err
+	}
+
+	if interactive {
+		confirm, err := readPassword("Confirm password: ")
+		if err != nil {
+			return err
+		}
+		if confirm != password {
+			return errors.New("passwords do not match")
+		}
+	}
+
+	if len(password) < 6 {
+		return errors.New("password must be at least 6 characters")
+	}
+
+	if err := db.UpdateAdminUserPassword(user.ID, password); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Password for %s has been reset and existing sessions signed out.\n", username)
+	if !user.Enabled {
+		fmt.Fprintf(out, "Note: %s is disabled and must be re-enabled by an owner before signing in.\n", username)
+	}
+	return nil
+}
+
+// resetAdminTwoFactor removes the TOTP secret and recovery codes for username.
+func resetAdminTwoFactor(username string, out io.Writer) error {
+	db, err := storage.NewDB(getEnv("DATABASE_PATH", "./shufflr.db"))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	user, err := db.GetAdminUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("admin user %q not found", username)
+	}
+
+	if err := db.DisableAdminUserTOTP(user.ID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Two-factor authentication for %s has been turned off.\n", username)
+	return nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", errors.New("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"shufflr/internal/admin"
+	"shufflr/internal/analytics"
 	"shufflr/internal/api"
 	"shufflr/internal/auth"
+	"shufflr/internal/certs"
+	"shufflr/internal/metrics"
+	"shufflr/internal/models"
+	"shufflr/internal/oidc"
+	"shufflr/internal/signing"
 	"shufflr/internal/storage"
+	"shufflr/internal/uploads"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type Config struct {
@@ -20,9 +33,27 @@ type Config struct {
 	UploadDir     string
 	SessionSecret string
 	BaseURL       string
+	TLSCertFile   string
+	TLSKeyFile    string
+	// Seconds browsers should only use HTTPS; 0 disables the HSTS header
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	// Serve /metrics on this address instead of Port when set
+	MetricsAddr string
+	// Bearer token required to read /metrics when set
+	MetricsToken string
 }
 
 func main() {
+	// Subcommands run offline against the database instead of starting the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	config := loadConfig()
 
 	// Ensure upload directory exists
@@ -39,14 +70,42 @@ func main() {
 
 	// Initialize auth service
 	authService := auth.NewAuthService(db, config.SessionSecret)
+	configureOIDC(authService, config.BaseURL)
+	configureProxyAuth(authService)
+	requestSigningSecret, err := loadRequestSigningSecret(config.DatabasePath)
+	if err != nil {
+		log.Fatalf("Failed to load request signing secret: %v", err)
+	}
+	authService.SetRequestSigningSecret(requestSigningSecret)
+	if value := os.Getenv("COOKIE_SECURE"); value != "" && value != "auto" {
+		secure, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Invalid COOKIE_SECURE: %s", value)
+		}
+		authService.SetSecureCookies(secure)
+	}
+
+	// Initialize image URL signer
+	signer, err := signing.NewSigner(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize URL signer: %v", err)
+	}
 
 	// Initialize servers
-	adminServer, err := admin.NewServer(db, authService, config.UploadDir, config.BaseURL)
+	adminServer, err := admin.NewServer(db, authService, signer, config.UploadDir, config.BaseURL)
 	if err != nil {
 		log.Fatalf("Failed to initialize admin server: %v", err)
 	}
 
-	apiServer := api.NewServer(db, authService, config.UploadDir)
+	// Image serve counts are batched in memory and saved periodically
+	recorder := analytics.NewRecorder(db)
+	go recorder.Run(analytics.FlushInterval)
+
+	apiServer := api.NewServer(db, authService, signer, config.UploadDir, recorder)
+
+	go emptyTrashPeriodically(db, config.UploadDir)
+	go compactRequestLogPeriodically(db)
+	go backfillThumbnails(db, config.UploadDir)
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -57,29 +116,53 @@ func main() {
 	// Health check
 	mux.HandleFunc("/health", apiServer.HandleHealth)
 
+	// Prometheus metrics, on the main port unless they have their own address
+	registerLibraryMetrics(db)
+	metricsHandler := metrics.RequireToken(config.MetricsToken, metrics.Handler())
+	var metricsServer *http.Server
+	if config.MetricsAddr == "" {
+		mux.Handle("/metrics", metricsHandler)
+	} else {
+		metricsServer = serveMetrics(config.MetricsAddr, metricsHandler)
+	}
+
 	// API routes
-	mux.HandleFunc("/api/images", func(w http.ResponseWriter, r *http.Request) {
+	// API requests count towards the usage statistics
+	mux.Handle("/api/images", recorder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
 			apiServer.HandleOptions(w, r)
 			return
 		}
+		if r.Method == http.MethodPost {
+			// Upload images (always requires a key with write scope)
+			authService.RequireAPIKey(models.ScopeImagesWrite, apiServer.HandleUploadImages)(w, r)
+			return
+		}
 		// Get random images (conditionally requires API key based on settings)
 		requireAPIKey, err := db.GetSetting("require_api_key_for_images")
 		if err != nil || requireAPIKey == "true" {
-			authService.RequireAPIKey(apiServer.HandleRandomImages)(w, r)
+			authService.RequireAPIKey(models.ScopeImagesRead, apiServer.HandleRandomImages)(w, r)
 		} else {
 			apiServer.HandleRandomImages(w, r)
 		}
-	})
+	})))
 	
-	mux.HandleFunc("/api/images/", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/images/", recorder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
 			apiServer.HandleOptions(w, r)
 			return
 		}
+		if r.Method == http.MethodDelete {
+			authService.RequireAPIKey(models.ScopeImagesWrite, apiServer.HandleDeleteImage)(w, r)
+			return
+		}
 		// Serve individual image (API key requirement handled within the handler)
 		apiServer.HandleServeImage(w, r)
-	})
+	})))
+
+	mux.Handle("/api/keys", recorder.Middleware(authService.RequireAPIKey(models.ScopeKeysManage, apiServer.HandleAPIKeys)))
+	mux.Handle("/api/keys/", recorder.Middleware(authService.RequireAPIKey(models.ScopeKeysManage, apiServer.HandleAPIKey)))
+	mux.Handle("/api/stats", recorder.Middleware(authService.RequireAPIKey(models.ScopeStatsRead, apiServer.HandleStats)))
 
 	// Admin routes
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -107,35 +190,226 @@ func main() {
 
 	mux.HandleFunc("/admin/setup", adminServer.HandleSetup)
 	mux.HandleFunc("/admin/login", adminServer.HandleLogin)
+	mux.HandleFunc("/admin/login/2fa", adminServer.HandleLoginTwoFactor)
+	mux.HandleFunc("/admin/login/oidc", adminServer.HandleOIDCLogin)
+	mux.HandleFunc("/admin/login/oidc/callback", adminServer.HandleOIDCCallback)
 	mux.HandleFunc("/admin/logout", adminServer.HandleLogout)
 
 	// Protected admin routes
+	mux.HandleFunc("/admin/usage/export", authService.RequireAdminAuth(adminServer.HandleUsageExport))
 	mux.HandleFunc("/admin/images", authService.RequireAdminAuth(adminServer.HandleImages))
 	mux.HandleFunc("/admin/images/serve/", authService.RequireAdminAuth(adminServer.HandleServeImage))
-	mux.HandleFunc("/admin/images/upload", authService.RequireAdminAuth(adminServer.HandleImageUpload))
-	mux.HandleFunc("/admin/images/rename", authService.RequireAdminAuth(adminServer.HandleImageRename))
-	mux.HandleFunc("/admin/images/delete", authService.RequireAdminAuth(adminServer.HandleImageDelete))
-	mux.HandleFunc("/admin/images/toggle", authService.RequireAdminAuth(adminServer.HandleToggleImage))
+	mux.HandleFunc("/admin/images/thumb/", authService.RequireAdminAuth(adminServer.HandleServeThumbnail))
+	mux.HandleFunc("/admin/images/upload", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleImageUpload))
+	mux.HandleFunc("/admin/images/rename", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleImageRename))
+	mux.HandleFunc("/admin/images/delete", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleImageDelete))
+	mux.HandleFunc("/admin/images/toggle", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleToggleImage))
+	mux.HandleFunc("/admin/images/metadata", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleImageMetadata))
+	mux.HandleFunc("/admin/images/schedule", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleImageSchedule))
+	mux.HandleFunc("/admin/images/bulk", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleBulkImages))
+	mux.HandleFunc("/admin/images/trash", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleTrash))
+	mux.HandleFunc("/admin/images/trash/serve/", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleServeTrashedImage))
+	mux.HandleFunc("/admin/images/trash/restore", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleRestoreImage))
+	mux.HandleFunc("/admin/images/trash/purge", authService.RequireAdminRole(models.RoleEditor, adminServer.HandlePurgeImage))
+	mux.HandleFunc("/admin/images/trash/empty", authService.RequireAdminRole(models.RoleEditor, adminServer.HandleEmptyTrash))
 
-	mux.HandleFunc("/admin/api-keys", authService.RequireAdminAuth(adminServer.HandleAPIKeys))
-	mux.HandleFunc("/admin/api-keys/new", authService.RequireAdminAuth(adminServer.HandleNewAPIKey))
-	mux.HandleFunc("/admin/api-keys/toggle", authService.RequireAdminAuth(adminServer.HandleToggleAPIKey))
-	mux.HandleFunc("/admin/api-keys/regenerate", authService.RequireAdminAuth(adminServer.HandleRegenerateAPIKey))
-	mux.HandleFunc("/admin/api-keys/delete", authService.RequireAdminAuth(adminServer.HandleDeleteAPIKey))
+	mux.HandleFunc("/admin/api-keys", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleAPIKeys))
+	mux.HandleFunc("/admin/api-keys/new", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleNewAPIKey))
+	mux.HandleFunc("/admin/api-keys/toggle", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleToggleAPIKey))
+	mux.HandleFunc("/admin/api-keys/scopes", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleAPIKeyScopes))
+	mux.HandleFunc("/admin/api-keys/limits", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleAPIKeyLimits))
+	mux.HandleFunc("/admin/api-keys/expiry", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleAPIKeyExpiry))
+	mux.HandleFunc("/admin/api-keys/restrictions", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleAPIKeyRestrictions))
+	mux.HandleFunc("/admin/api-keys/regenerate", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleRegenerateAPIKey))
+	mux.HandleFunc("/admin/api-keys/delete", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleDeleteAPIKey))
 
-	mux.HandleFunc("/admin/settings", authService.RequireAdminAuth(adminServer.HandleSettings))
+	mux.HandleFunc("/admin/settings", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleSettings))
+	mux.HandleFunc("/admin/settings/rotate-signing-secret", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleRotateSigningSecret))
+	mux.HandleFunc("/admin/settings/compact-request-log", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleCompactRequestLog))
 
-	// Add request logging middleware
-	handler := loggingMiddleware(mux)
+	mux.HandleFunc("/admin/account", authService.RequireAdminAuth(adminServer.HandleAccount))
+	mux.HandleFunc("/admin/account/password", authService.RequireAdminAuth(adminServer.HandleChangePassword))
+	mux.HandleFunc("/admin/account/2fa/setup", authService.RequireAdminAuth(adminServer.HandleTwoFactorSetup))
+	mux.HandleFunc("/admin/account/2fa/enable", authService.RequireAdminAuth(adminServer.HandleTwoFactorEnable))
+	mux.HandleFunc("/admin/account/2fa/disable", authService.RequireAdminAuth(adminServer.HandleTwoFactorDisable))
+	mux.HandleFunc("/admin/account/2fa/recovery-codes", authService.RequireAdminAuth(adminServer.HandleRecoveryCodes))
+	mux.HandleFunc("/admin/account/sessions", authService.RequireAdminAuth(adminServer.HandleSessions))
+	mux.HandleFunc("/admin/account/sessions/revoke", authService.RequireAdminAuth(adminServer.HandleRevokeSession))
+	mux.HandleFunc("/admin/account/sessions/revoke-others", authService.RequireAdminAuth(adminServer.HandleRevokeOtherSessions))
+
+	mux.HandleFunc("/admin/users", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleUsers))
+	mux.HandleFunc("/admin/users/new", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleNewUser))
+	mux.HandleFunc("/admin/users/role", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleUserRole))
+	mux.HandleFunc("/admin/users/toggle", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleToggleUser))
+	mux.HandleFunc("/admin/users/reset-password", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleResetUserPassword))
+	mux.HandleFunc("/admin/users/delete", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleDeleteUser))
+	mux.HandleFunc("/admin/users/reset-2fa", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleResetUserTwoFactor))
+	mux.HandleFunc("/admin/users/logins", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleLoginAttempts))
+	mux.HandleFunc("/admin/users/unlock", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleUnlockLogin))
+	mux.HandleFunc("/admin/users/sessions", authService.RequireAdminRole(models.RoleOwner, adminServer.HandleAllSessions))
+
+	// Add request metrics, request logging, HSTS and CSRF protection middleware
+	handler := metrics.Middleware(mux, loggingMiddleware(hstsMiddleware(config, authService.RequireCSRF(mux, config.BaseURL))))
 
 	log.Printf("Starting Shufflr server on port %s", config.Port)
 	log.Printf("Upload directory: %s", config.UploadDir)
 	log.Printf("Database: %s", config.DatabasePath)
 	log.Printf("Base URL: %s", config.BaseURL)
 
-	if err := http.ListenAndServe(":"+config.Port, handler); err != nil {
+	server := &http.Server{Addr: ":" + config.Port, Handler: handler}
+	stopped := shutdownOnSignal(recorder, server, metricsServer)
+	if config.TLSCertFile != "" {
+		reloader, err := certs.NewReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+		reloadOnSignal(reloader)
+
+		log.Printf("Serving HTTPS")
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+
+	// Wait for in-flight requests and the final flush before closing the
+	// database
+	<-stopped
+}
+
+// emptyTrashPeriodically purges images that have been in the trash for
+// longer than the retention setting.
+func emptyTrashPeriodically(db *storage.DB, uploadDir string) {
+	for {
+		purged, err := uploads.PurgeExpired(db, uploadDir)
+		if err != nil {
+			log.Printf("Error emptying trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Permanently deleted %d image(s) from the trash", purged)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// compactRequestLogPeriodically deletes API request log entries older than
+// the retention setting. Usage statistics are counted live rather than
+// rolled up from the log, so nothing needs to be aggregated first.
+func compactRequestLogPeriodically(db *storage.DB) {
+	for {
+		deleted, err := analytics.CompactRequestLog(db)
+		if err != nil {
+			log.Printf("Error compacting request log: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Deleted %d API request log entries", deleted)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// backfillThumbnails creates thumbnails for images uploaded before
+// thumbnails existed, or whose thumbnails were deleted.
+func backfillThumbnails(db *storage.DB, uploadDir string) {
+	created, err := uploads.BackfillThumbnails(db, uploadDir)
+	if err != nil {
+		log.Printf("Error creating thumbnails: %v", err)
+	} else if created > 0 {
+		log.Printf("Created %d thumbnail(s)", created)
+	}
+}
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// shutdownOnSignal stops the servers gracefully when the process receives
+// SIGINT or SIGTERM, then saves the pending usage statistics. The returned
+// channel is closed once that is done. Nil servers are skipped.
+func shutdownOnSignal(recorder *analytics.Recorder, servers ...*http.Server) <-chan struct{} {
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			if server == nil {
+				continue
+			}
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Error shutting down server: %v", err)
+			}
+		}
+
+		if err := recorder.Flush(); err != nil {
+			log.Printf("Error saving usage statistics: %v", err)
+		}
+		close(stopped)
+	}()
+	return stopped
+}
+
+// registerLibraryMetrics adds gauges for the image library and API keys,
+// read from the database on each scrape.
+func registerLibraryMetrics(db *storage.DB) {
+	metrics.NewGaugeFunc("shufflr_library_images", "Images in the library, not counting the trash.", func() (float64, error) {
+		count, _, err := db.GetLibrarySize()
+		return float64(count), err
+	})
+	metrics.NewGaugeFunc("shufflr_library_bytes", "Total size of the images in the library, not counting the trash.", func() (float64, error) {
+		_, size, err := db.GetLibrarySize()
+		return float64(size), err
+	})
+	metrics.NewGaugeFunc("shufflr_library_servable_images", "Images that are enabled and inside their publishing window.", func() (float64, error) {
+		count, err := db.GetImageFileCount()
+		return float64(count), err
+	})
+	metrics.NewGaugeFunc("shufflr_active_api_keys", "API keys that are enabled and haven't expired.", func() (float64, error) {
+		keys, err := db.GetAllAPIKeys()
+		if err != nil {
+			return 0, err
+		}
+		active := 0
+		for _, key := range keys {
+			if key.Enabled && !key.IsExpired() {
+				active++
+			}
+		}
+		return float64(active), nil
+	})
+}
+
+// serveMetrics serves /metrics on its own address, e.g. one only reachable
+// from the monitoring network.
+func serveMetrics(addr string, handler http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{Addr: addr, Handler: mux}
+	log.Printf("Serving metrics on %s", addr)
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Metrics server failed to start: %v", err)
+		}
+	}()
+	return server
+}
+
+// reloadOnSignal reloads the TLS certificate on SIGHUP, for renewal hooks
+// that don't want to wait for the periodic check.
+func reloadOnSignal(reloader *certs.Reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("Error reloading TLS certificate: %v", err)
+			}
+		}
+	}()
 }
 
 func loadConfig() Config {
@@ -144,8 +418,24 @@ func loadConfig() Config {
 		DatabasePath: getEnv("DATABASE_PATH", "./shufflr.db"),
 		UploadDir:    getEnv("UPLOAD_DIR", "./uploads"),
 		BaseURL:      getEnv("BASE_URL", "http://localhost:8080"),
+		TLSCertFile:  os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:   os.Getenv("TLS_KEY_FILE"),
+		MetricsAddr:  os.Getenv("METRICS_ADDR"),
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+
+		HSTSIncludeSubdomains: os.Getenv("HSTS_INCLUDE_SUBDOMAINS") == "true",
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
 
+	hstsMaxAge, err := strconv.Atoi(getEnv("HSTS_MAX_AGE", "31536000"))
+	if err != nil || hstsMaxAge < 0 {
+		log.Fatalf("Invalid HSTS_MAX_AGE: %s", os.Getenv("HSTS_MAX_AGE"))
+	}
+	config.HSTSMaxAge = hstsMaxAge
+
 	// Generate or load session secret
 	sessionSecret := os.Getenv("SESSION_SECRET")
 	if sessionSecret == "" {
@@ -185,6 +475,119 @@ func loadConfig() Config {
 	return config
 }
 
+// configureOIDC enables single sign-on for the admin UI when OIDC_ISSUER_URL
+// is set.
+func configureOIDC(authService *auth.AuthService, baseURL string) {
+	issuerURL := os.Getenv("OIDC_ISSUER_URL")
+	if issuerURL == "" {
+		if os.Getenv("DISABLE_PASSWORD_LOGIN") == "true" {
+			log.Fatalf("DISABLE_PASSWORD_LOGIN requires single sign-on to be configured with OIDC_ISSUER_URL")
+		}
+		return
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		log.Fatalf("OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set")
+	}
+
+	roleMapping, err := auth.ParseRoleMapping(os.Getenv("OIDC_ROLE_MAPPING"))
+	if err != nil {
+		log.Fatalf("Invalid OIDC_ROLE_MAPPING: %v", err)
+	}
+	defaultRole := os.Getenv("OIDC_DEFAULT_ROLE")
+	if defaultRole != "" && !models.IsValidRole(defaultRole) {
+		log.Fatalf("Invalid OIDC_DEFAULT_ROLE: %s", defaultRole)
+	}
+
+	provider := oidc.NewProvider(oidc.Config{
+		IssuerURL:    issuerURL,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL", strings.TrimRight(baseURL, "/")+"/admin/login/oidc/callback"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	})
+
+	authService.EnableOIDC(auth.OIDCOptions{
+		Provider:             provider,
+		UsernameClaim:        os.Getenv("OIDC_USERNAME_CLAIM"),
+		RoleClaim:            os.Getenv("OIDC_ROLE_CLAIM"),
+		RoleMapping:          roleMapping,
+		DefaultRole:          defaultRole,
+		DisablePasswordLogin: os.Getenv("DISABLE_PASSWORD_LOGIN") == "true",
+	})
+	log.Printf("Single sign-on enabled with %s", issuerURL)
+}
+
+// configureProxyAuth trusts the username header set by an authenticating
+// reverse proxy when AUTH_PROXY_HEADER is set.
+func configureProxyAuth(authService *auth.AuthService) {
+	header := os.Getenv("AUTH_PROXY_HEADER")
+	if header == "" {
+		return
+	}
+
+	var entries []string
+	for _, entry := range strings.Split(os.Getenv("AUTH_PROXY_TRUSTED_IPS"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		log.Fatalf("AUTH_PROXY_TRUSTED_IPS is required when AUTH_PROXY_HEADER is set")
+	}
+	trusted, err := auth.ParseIPAllowlist(entries)
+	if err != nil {
+		log.Fatalf("Invalid AUTH_PROXY_TRUSTED_IPS: %v", err)
+	}
+
+	defaultRole := getEnv("AUTH_PROXY_DEFAULT_ROLE", models.RoleViewer)
+	if !models.IsValidRole(defaultRole) {
+		log.Fatalf("Invalid AUTH_PROXY_DEFAULT_ROLE: %s", defaultRole)
+	}
+
+	authService.EnableProxyAuth(auth.ProxyAuthOptions{
+		Header:         header,
+		TrustedProxies: trusted,
+		DefaultRole:    defaultRole,
+	})
+	log.Printf("Trusting %s header from %s", header, strings.Join(entries, ", "))
+}
+
+// loadRequestSigningSecret returns REQUEST_SIGNING_SECRET, or else the
+// secret kept in request-signing.key next to the database, creating it on
+// first start. The signing secrets of API keys are derived from it, so it
+// must not be stored in the database itself.
+func loadRequestSigningSecret(databasePath string) ([]byte, error) {
+	if secret := os.Getenv("REQUEST_SIGNING_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+
+	path := filepath.Join(filepath.Dir(databasePath), "request-signing.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("%s is empty", path)
+		}
+		return []byte(secret), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	secret := hex.EncodeToString(bytes)
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Printf("Generated request signing secret in %s", path)
+	return []byte(secret), nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -192,10 +595,28 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// hstsMiddleware tells browsers to only use HTTPS from now on. The header is
+// only sent on HTTPS responses, as browsers ignore it over plain HTTP.
+func hstsMiddleware(config Config, next http.Handler) http.Handler {
+	if config.HSTSMaxAge == 0 {
+		return next
+	}
+	value := "max-age=" + strconv.Itoa(config.HSTSMaxAge)
+	if config.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.IsHTTPS(r) {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip logging for health checks to reduce noise
-		if r.URL.Path != "/health" {
+		// Skip logging for health checks and metrics scrapes to reduce noise
+		if r.URL.Path != "/health" && r.URL.Path != "/metrics" {
 			log.Printf("%s %s %s", r.Method, r.URL.Path, r.RemoteAddr)
 		}
 		next.ServeHTTP(w, r)
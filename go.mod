@@ -6,6 +6,11 @@ require (
 	github.com/gorilla/sessions v1.2.2
 	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/crypto v0.17.0
+	golang.org/x/image v0.18.0
+	golang.org/x/term v0.15.0
 )
 
-require github.com/gorilla/securecookie v1.1.2 // indirect
+require (
+	github.com/gorilla/securecookie v1.1.2 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
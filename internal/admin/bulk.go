@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"shufflr/internal/models"
+	"shufflr/internal/uploads"
+	"strings"
+)
+
+// Limits on bulk actions
+const (
+	maxTagLength        = 50
+	maxCollectionLength = 100
+	maxBulkImages       = 1000
+)
+
+// BulkImagesResponse is returned by HandleBulkImages.
+type BulkImagesResponse struct {
+	Action   string                   `json:"action"`
+	Updated  int                      `json:"updated"`
+	NotFound int                      `json:"not_found"`
+	Results  []models.BulkImageResult `json:"results"`
+}
+
+// HandleBulkImages applies an action to the selected images in a single
+// transaction and responds with JSON describing the outcome for each image.
+// The form holds the action, one filename field per image, and the tags or
+// collection the action needs.
+func (s *Server) HandleBulkImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid form data")
+		return
+	}
+
+	filenames := r.Form["filename"]
+	if len(filenames) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Select at least one image")
+		return
+	}
+	if len(filenames) > maxBulkImages {
+		writeJSONError(w, http.StatusBadRequest, "Too many images selected")
+		return
+	}
+
+	action := r.FormValue("action")
+	update, errMsg := parseBulkUpdate(action, r.FormValue("tags"), r.FormValue("collection"))
+	if errMsg != "" {
+		writeJSONError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	results, err := uploads.BulkUpdate(s.db, s.uploadDir, filenames, update)
+	if err != nil {
+		log.Printf("Error applying bulk %s: %v", action, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update images, no changes were made")
+		return
+	}
+
+	response := BulkImagesResponse{Action: action, Results: results}
+	for _, result := range results {
+		if result.Status == models.BulkResultUpdated {
+			response.Updated++
+		} else {
+			response.NotFound++
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseBulkUpdate turns a bulk action and its form values into an update,
+// returning an error message if they are invalid.
+func parseBulkUpdate(action, tags, collection string) (models.BulkImageUpdate, string) {
+	var update models.BulkImageUpdate
+	switch action {
+	case models.BulkActionEnable, models.BulkActionDisable:
+		enabled := action == models.BulkActionEnable
+		update.Enabled = &enabled
+	case models.BulkActionDelete:
+		update.Trash = true
+	case models.BulkActionTag, models.BulkActionUntag:
+		parsed, errMsg := parseTags(tags)
+		if errMsg != "" {
+			return update, errMsg
+		}
+		if action == models.BulkActionTag {
+			update.AddTags = parsed
+		} else {
+			update.RemoveTags = parsed
+		}
+	case models.BulkActionMove:
+		collection = strings.TrimSpace(collection)
+		if len(collection) > maxCollectionLength {
+			return update, "Collection name is too long"
+		}
+		update.Collection = &collection
+	default:
+		return update, "Unknown action"
+	}
+	return update, ""
+}
+
+// parseTags splits a comma separated list of tags. Tags are lowercased so
+// "Summer" and "summer" are the same tag.
+func parseTags(value string) ([]string, string) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, "Tag is too long: " + tag
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, "Enter at least one tag"
+	}
+	return tags, ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
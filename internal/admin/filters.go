@@ -0,0 +1,192 @@
+package admin
+
+import (
+	"net/http"
+	"shufflr/internal/models"
+	"shufflr/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes offered in the image library
+var imagePageSizes = []int{25, 50, 100, 200}
+
+const defaultImagePageSize = 50
+
+// Format of the date inputs in the image library filters, in UTC like the
+// dates shown in the library
+const filterDateLayout = "2006-01-02"
+
+// imageFilters holds the image library's search, filter and sort form values
+// as they were submitted, so the form can show them again.
+type imageFilters struct {
+	Search     string
+	Status     string
+	MimeType   string
+	Tag        string
+	Collection string
+	From       string
+	To         string
+	MinKB      string
+	MaxKB      string
+	Sort       string
+	PerPage    int
+	Page       int
+}
+
+// Active reports whether any filter narrows the listing.
+func (f imageFilters) Active() bool {
+	return f.Search != "" || f.Status != "" || f.MimeType != "" || f.Tag != "" || f.Collection != "" ||
+		f.From != "" || f.To != "" || f.MinKB != "" || f.MaxKB != ""
+}
+
+// parseImageFilters reads the image library query string. Invalid values are
+// dropped, and a message describing the first one is returned.
+func parseImageFilters(r *http.Request) (imageFilters, models.ImageQuery, string) {
+	values := r.URL.Query()
+	var errMsg string
+	invalid := func(message string) {
+		if errMsg == "" {
+			errMsg = message
+		}
+	}
+
+	f := imageFilters{
+		Search:     strings.TrimSpace(values.Get("q")),
+		MimeType:   values.Get("type"),
+		Tag:        values.Get("tag"),
+		Collection: values.Get("collection"),
+		Sort:       values.Get("sort"),
+		PerPage:    defaultImagePageSize,
+		Page:       1,
+	}
+	q := models.ImageQuery{
+		Search:     f.Search,
+		MimeType:   f.MimeType,
+		Tag:        f.Tag,
+		Collection: f.Collection,
+	}
+
+	switch status := values.Get("status"); status {
+	case "enabled", "disabled":
+		enabled := status == "enabled"
+		f.Status = status
+		q.Enabled = &enabled
+	case models.PublishStateScheduled, models.PublishStateExpired:
+		f.Status = status
+		q.PublishState = status
+	case "never_served":
+		f.Status = status
+		q.NeverServed = true
+	}
+
+	if from := values.Get("from"); from != "" {
+		if t, err := time.Parse(filterDateLayout, from); err == nil {
+			f.From = from
+			q.UploadedAfter = t
+		} else {
+			invalid("Invalid start date")
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		if t, err := time.Parse(filterDateLayout, to); err == nil {
+			f.To = to
+			// Include the whole end day
+			q.UploadedBefore = t.AddDate(0, 0, 1)
+		} else {
+			invalid("Invalid end date")
+		}
+	}
+
+	if minKB := values.Get("min_kb"); minKB != "" {
+		if kb, err := strconv.ParseInt(minKB, 10, 64); err == nil && kb >= 0 {
+			f.MinKB = minKB
+			q.MinSize = kb * 1024
+		} else {
+			invalid("Minimum size must be a whole number of KB")
+		}
+	}
+	if maxKB := values.Get("max_kb"); maxKB != "" {
+		if kb, err := strconv.ParseInt(maxKB, 10, 64); err == nil && kb >= 0 {
+			f.MaxKB = maxKB
+			q.MaxSize = kb * 1024
+		} else {
+			invalid("Maximum size must be a whole number of KB")
+		}
+	}
+
+	if !storage.IsValidImageSort(f.Sort) {
+		f.Sort = models.ImageSortNewest
+	}
+	q.Sort = f.Sort
+
+	if perPage, err := strconv.Atoi(values.Get("per_page")); err == nil {
+		for _, size := range imagePageSizes {
+			if perPage == size {
+				f.PerPage = perPage
+			}
+		}
+	}
+	if page, err := strconv.Atoi(values.Get("page")); err == nil && page > 1 {
+		f.Page = page
+	}
+	q.Limit = f.PerPage
+	q.Offset = (f.Page - 1) * f.PerPage
+
+	return f, q, errMsg
+}
+
+// Pagination describes the links between pages of a listing.
+type Pagination struct {
+	Page       int
+	TotalPages int
+	PrevURL    string
+	NextURL    string
+	Pages      []PageLink
+}
+
+// PageLink is a numbered page link. A zero Number is a gap in the list.
+type PageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// newPagination builds links for page out of totalPages, keeping the other
+// query parameters of the current URL.
+func newPagination(r *http.Request, page, totalPages int) Pagination {
+	p := Pagination{Page: page, TotalPages: totalPages}
+	if totalPages <= 1 {
+		return p
+	}
+
+	values := r.URL.Query()
+	values.Del("success")
+	values.Del("error")
+	pageURL := func(n int) string {
+		values.Set("page", strconv.Itoa(n))
+		return r.URL.Path + "?" + values.Encode()
+	}
+
+	if page > 1 {
+		p.PrevURL = pageURL(page - 1)
+	}
+	if page < totalPages {
+		p.NextURL = pageURL(page + 1)
+	}
+
+	// The first and last pages and two either side of the current one
+	last := 0
+	for n := 1; n <= totalPages; n++ {
+		if n != 1 && n != totalPages && (n < page-2 || n > page+2) {
+			continue
+		}
+		if last != 0 && n > last+1 {
+			p.Pages = append(p.Pages, PageLink{})
+		}
+		p.Pages = append(p.Pages, PageLink{Number: n, URL: pageURL(n), Current: n == page})
+		last = n
+	}
+	return p
+}
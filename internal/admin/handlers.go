@@ -3,31 +3,40 @@ package admin
 import (
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"shufflr/internal/analytics"
 	"shufflr/internal/auth"
 	"shufflr/internal/models"
+	"shufflr/internal/signing"
 	"shufflr/internal/storage"
+	"shufflr/internal/uploads"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Server struct {
 	db          *storage.DB
 	authService *auth.AuthService
+	signer      *signing.Signer
 	uploadDir   string
 	baseURL     string
+
+	// Filenames of images whose thumbnail couldn't be created
+	failedThumbnails sync.Map
 }
 
-func NewServer(db *storage.DB, authService *auth.AuthService, uploadDir, baseURL string) (*Server, error) {
+func NewServer(db *storage.DB, authService *auth.AuthService, signer *signing.Signer, uploadDir, baseURL string) (*Server, error) {
 	return &Server{
 		db:          db,
 		authService: authService,
+		signer:      signer,
 		uploadDir:   uploadDir,
 		baseURL:     baseURL,
 	}, nil
@@ -38,9 +47,22 @@ type PageData struct {
 	ShowNav   bool
 	ActivePage string
 	Username  string
+	Role      string
 	BaseURL   string
 	Success   string
 	Error     string
+	// Must be submitted with every POST form; see auth.RequireCSRF
+	CSRFToken string
+}
+
+// IsOwner reports whether the signed-in admin may manage keys, settings and users.
+func (p PageData) IsOwner() bool {
+	return p.Role == models.RoleOwner
+}
+
+// CanEdit reports whether the signed-in admin may change images.
+func (p PageData) CanEdit() bool {
+	return p.Role == models.RoleOwner || p.Role == models.RoleEditor
 }
 
 // Setup and login handlers
@@ -52,14 +74,16 @@ func (s *Server) HandleSetup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if hasAdmins {
+	// Without password sign-in the first owner is created by single sign-on
+	if hasAdmins || !s.authService.PasswordLoginEnabled() {
 		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
 		return
 	}
 
 	data := PageData{
-		Title:   "Setup",
-		ShowNav: false,
+		Title:     "Setup",
+		ShowNav:   false,
+		CSRFToken: s.authService.CSRFToken(w, r),
 	}
 
 	if r.Method == http.MethodPost {
@@ -67,16 +91,10 @@ func (s *Server) HandleSetup(w http.ResponseWriter, r *http.Request) {
 		password := r.FormValue("password")
 		confirmPassword := r.FormValue("confirm_password")
 
-		if username == "" || password == "" {
-			data.Error = "Username and password are required"
-		} else if len(username) < 3 {
-			data.Error = "Username must be at least 3 characters"
-		} else if len(password) < 6 {
-			data.Error = "Password must be at least 6 characters"
-		} else if password != confirmPassword {
-			data.Error = "Passwords do not match"
+		if msg := validateCredentials(username, password, confirmPassword); msg != "" {
+			data.Error = msg
 		} else {
-			_, err := s.db.CreateAdminUser(username, password)
+			_, err := s.db.CreateAdminUser(username, password, models.RoleOwner)
 			if err != nil {
 				log.Printf("Error creating admin user: %v", err)
 				data.Error = "Failed to create admin user"
@@ -91,31 +109,65 @@ func (s *Server) HandleSetup(w http.ResponseWriter, r *http.Request) {
 	s.renderTemplate(w, "setup.html", data)
 }
 
-func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	data := PageData{
-		Title:   "Login",
-		ShowNav: false,
-		Success: r.URL.Query().Get("success"),
+// loginPage is the data for login.html.
+type loginPage struct {
+	PageData
+	SSOEnabled    bool
+	PasswordLogin bool
+}
+
+func (s *Server) newLoginPage(w http.ResponseWriter, r *http.Request) loginPage {
+	return loginPage{
+		PageData: PageData{
+			Title:     "Login",
+			ShowNav:   false,
+			Success:   r.URL.Query().Get("success"),
+			CSRFToken: s.authService.CSRFToken(w, r),
+		},
+		SSOEnabled:    s.authService.OIDCEnabled(),
+		PasswordLogin: s.authService.PasswordLoginEnabled(),
 	}
+}
+
+func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	data := s.newLoginPage(w, r)
 
 	if r.Method == http.MethodPost {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
-		if username == "" || password == "" {
+		if !data.PasswordLogin {
+			data.Error = "Password sign-in is disabled. Use single sign-on."
+		} else if username == "" || password == "" {
 			data.Error = "Username and password are required"
+		} else if wait, err := s.authService.CheckLoginAllowed(r, username); err != nil {
+			log.Printf("Error checking login attempts: %v", err)
+			data.Error = "Login failed"
+		} else if wait > 0 {
+			data.Error = tooManyAttemptsMessage(wait)
 		} else {
 			user, err := s.authService.LoginAdmin(username, password)
 			if err != nil {
 				log.Printf("Error during login: %v", err)
 				data.Error = "Login failed"
 			} else if user == nil {
+				s.authService.RecordLoginAttempt(r, username, models.LoginStagePassword, models.LoginResultFailure)
 				data.Error = "Invalid username or password"
+			} else if user.TOTPEnabled {
+				// Password accepted; ask for the second factor before signing in
+				if err := s.authService.SetPendingTwoFactor(w, r, user); err != nil {
+					log.Printf("Error setting session: %v", err)
+					data.Error = "Login failed"
+				} else {
+					http.Redirect(w, r, "/admin/login/2fa", http.StatusSeeOther)
+					return
+				}
 			} else {
-				if err := s.authService.SetAdminSession(w, r, user); err != nil {
+				if err := s.authService.SetAdminSession(w, r, user, models.AuthMethodPassword); err != nil {
 					log.Printf("Error setting session: %v", err)
 					data.Error = "Login failed"
 				} else {
+					s.authService.RecordLoginAttempt(r, username, models.LoginStagePassword, models.LoginResultSuccess)
 					http.Redirect(w, r, "/admin", http.StatusSeeOther)
 					return
 				}
@@ -127,6 +179,50 @@ func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	s.renderTemplate(w, "login.html", data)
 }
 
+// HandleLoginTwoFactor is the second login step for admins with 2FA enabled.
+func (s *Server) HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := s.authService.GetPendingTwoFactor(r)
+	if err != nil {
+		log.Printf("Error checking pending login: %v", err)
+	}
+	if user == nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	data := PageData{
+		Title:     "Two-Factor Authentication",
+		ShowNav:   false,
+		Username:  user.Username,
+		CSRFToken: s.authService.CSRFToken(w, r),
+	}
+
+	if r.Method == http.MethodPost {
+		// Failed codes count towards the same limits as failed passwords
+		if wait, err := s.authService.CheckLoginAllowed(r, user.Username); err != nil {
+			log.Printf("Error checking login attempts: %v", err)
+			data.Error = "Login failed"
+		} else if wait > 0 {
+			data.Error = tooManyAttemptsMessage(wait)
+		} else if ok, err := s.authService.VerifySecondFactor(user, r.FormValue("code")); err != nil {
+			log.Printf("Error verifying second factor: %v", err)
+			data.Error = "Login failed"
+		} else if !ok {
+			s.authService.RecordLoginAttempt(r, user.Username, models.LoginStageTwoFactor, models.LoginResultFailure)
+			data.Error = "Invalid authentication code"
+		} else if err := s.authService.SetAdminSession(w, r, user, models.AuthMethodPassword); err != nil {
+			log.Printf("Error setting session: %v", err)
+			data.Error = "Login failed"
+		} else {
+			s.authService.RecordLoginAttempt(r, user.Username, models.LoginStageTwoFactor, models.LoginResultSuccess)
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+			return
+		}
+	}
+
+	s.renderTemplate(w, "login-2fa.html", data)
+}
+
 func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	if err := s.authService.ClearAdminSession(w, r); err != nil {
 		log.Printf("Error clearing session: %v", err)
@@ -134,6 +230,9 @@ func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
 }
 
+// Number of images listed in each of the dashboard's image cards
+const dashboardImageCount = 5
+
 // Dashboard
 func (s *Server) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetAdminFromContext(r.Context())
@@ -163,12 +262,45 @@ func (s *Server) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get total request count across all API keys
-	requestCount := 0
-	for _, key := range apiKeys {
-		count, err := s.db.GetAPIKeyUsageCount(key.ID)
-		if err == nil {
-			requestCount += count
+	allTimeUsage, err := s.db.GetAllTimeUsage()
+	if err != nil {
+		log.Printf("Error getting total usage: %v", err)
+		allTimeUsage = &models.UsageCounts{}
+	}
+
+	// Usage over the selected range. Only owners, who manage the keys, see
+	// it broken down by key.
+	usage, err := s.loadUsage(parseUsageRange(r.URL.Query().Get("range")), user.HasRole(models.RoleOwner))
+	if err != nil {
+		log.Printf("Error getting usage: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The most served images, and images nobody has been shown yet
+	topImages, err := s.db.QueryImageFiles(models.ImageQuery{Sort: models.ImageSortMostServed, Limit: dashboardImageCount})
+	if err != nil {
+		log.Printf("Error getting most served images: %v", err)
+		topImages = &models.ImagePage{}
+	}
+	var mostServed []*models.ImageFile
+	for _, img := range topImages.Images {
+		if img.ServedCount > 0 || img.FetchedCount > 0 {
+			mostServed = append(mostServed, img)
+		}
+	}
+	neverServed, err := s.db.QueryImageFiles(models.ImageQuery{NeverServed: true, Sort: models.ImageSortOldest, Limit: dashboardImageCount})
+	if err != nil {
+		log.Printf("Error getting never served images: %v", err)
+		neverServed = &models.ImagePage{}
+	}
+
+	// Owners are told about lockouts so they can spot attacks or unlock a colleague
+	var lockouts []auth.Lockout
+	if user.HasRole(models.RoleOwner) {
+		lockouts, err = s.authService.GetLockouts()
+		if err != nil {
+			log.Printf("Error getting lockouts: %v", err)
 		}
 	}
 
@@ -179,20 +311,35 @@ func (s *Server) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 		APIKeyCount       int
 		ActiveAPIKeyCount int
 		RequestCount      int
+		Usage             *usageReport
+		UsageChart        usageChart
+		UsageRanges       []usageRange
+		MostServedImages  []*models.ImageFile
+		NeverServed       *models.ImagePage
+		Lockouts          []auth.Lockout
 	}{
 		PageData: PageData{
 			Title:      "Dashboard",
 			ShowNav:    true,
 			ActivePage: "dashboard",
 			Username:   user.Username,
+			Role:       user.Role,
 			BaseURL:    s.baseURL,
 			Success:    r.URL.Query().Get("success"),
+			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
 		},
 		EnabledImageCount: enabledImageCount,
 		TotalImageCount:   totalImageCount,
 		APIKeyCount:       len(apiKeys),
 		ActiveAPIKeyCount: activeAPIKeyCount,
-		RequestCount:      requestCount,
+		RequestCount:      allTimeUsage.Requests,
+		Usage:             usage,
+		UsageChart:        usage.chart(),
+		UsageRanges:       usageRanges,
+		MostServedImages:  mostServed,
+		NeverServed:       neverServed,
+		Lockouts:          lockouts,
 	}
 
 	s.renderTemplate(w, "dashboard.html", data)
@@ -201,46 +348,99 @@ func (s *Server) HandleDashboard(w http.ResponseWriter, r *http.Request) {
 // Image management
 func (s *Server) HandleImages(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetAdminFromContext(r.Context())
-	
-	images, err := s.db.GetAllImageFiles()
+
+	filters, query, filterError := parseImageFilters(r)
+	page, err := s.db.QueryImageFiles(query)
+	if err == nil && len(page.Images) == 0 && page.Total > 0 {
+		// Past the last page, e.g. after deleting its images
+		filters.Page = (page.Total + filters.PerPage - 1) / filters.PerPage
+		query.Offset = (filters.Page - 1) * filters.PerPage
+		page, err = s.db.QueryImageFiles(query)
+	}
 	if err != nil {
 		log.Printf("Error getting images: %v", err)
-		images = []*models.ImageFile{}
+		page = &models.ImagePage{}
 	}
+	images := page.Images
 
 	// Add formatted data for display
 	type ImageDisplay struct {
 		*models.ImageFile
 		SizeFormatted        string
 		UploadedAtFormatted  string
+		PublishState         string
+		// Values for the schedule form's datetime-local inputs
+		PublishAtInput       string
+		UnpublishAtInput     string
 	}
 
+	now := time.Now()
 	displayImages := make([]ImageDisplay, len(images))
-	var totalSize int64
 	for i, img := range images {
-		totalSize += img.Size
 		displayImages[i] = ImageDisplay{
 			ImageFile:           img,
 			SizeFormatted:       formatFileSize(img.Size),
 			UploadedAtFormatted: img.UploadedAt.Format("Jan 2, 2006"),
+			PublishState:        img.PublishState(now),
+		}
+		if img.PublishAt != nil {
+			displayImages[i].PublishAtInput = img.PublishAt.UTC().Format(scheduleInputLayout)
 		}
+		if img.UnpublishAt != nil {
+			displayImages[i].UnpublishAtInput = img.UnpublishAt.UTC().Format(scheduleInputLayout)
+		}
+	}
+
+	// Offered as filters and as suggestions in the bulk tag and move dialogs
+	tags, err := s.db.GetAllImageTags()
+	if err != nil {
+		log.Printf("Error getting image tags: %v", err)
+	}
+	collections, err := s.db.GetAllImageCollections()
+	if err != nil {
+		log.Printf("Error getting image collections: %v", err)
+	}
+	mimeTypes, err := s.db.GetAllImageMimeTypes()
+	if err != nil {
+		log.Printf("Error getting image types: %v", err)
+	}
+
+	errorMessage := r.URL.Query().Get("error")
+	if errorMessage == "" {
+		errorMessage = filterError
 	}
 
 	data := struct {
 		PageData
 		Images              []ImageDisplay
+		TotalImages         int
 		TotalSizeFormatted  string
+		Filters             imageFilters
+		Pagination          Pagination
+		PageSizes           []int
+		Tags                []string
+		Collections         []string
+		MimeTypes           []string
 	}{
 		PageData: PageData{
 			Title:      "Images",
 			ShowNav:    true,
 			ActivePage: "images",
 			Username:   user.Username,
+			Role:       user.Role,
 			Success:    r.URL.Query().Get("success"),
-			Error:      r.URL.Query().Get("error"),
+			Error:      errorMessage,
+			CSRFToken:  s.authService.CSRFToken(w, r),
 		},
 		Images:             displayImages,
-		TotalSizeFormatted: formatFileSize(totalSize),
+		TotalImages:        page.Total,
+		TotalSizeFormatted: formatFileSize(page.TotalSize),
+		Filters:            filters,
+		Pagination:         newPagination(r, filters.Page, (page.Total+filters.PerPage-1)/filters.PerPage),
+		PageSizes:          imagePageSizes,
+		Tags:               tags,
+		Collections:        collections,
+		MimeTypes:          mimeTypes,
 	}
 
 	s.renderTemplate(w, "images.html", data)
@@ -255,6 +455,8 @@ func (s *Server) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
 			ShowNav:    true,
 			ActivePage: "images",
 			Username:   user.Username,
+			Role:       user.Role,
+			CSRFToken:  s.authService.CSRFToken(w, r),
 		}
 		s.renderTemplate(w, "upload.html", data)
 		return
@@ -296,61 +498,8 @@ func (s *Server) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) uploadFile(fileHeader *multipart.FileHeader) error {
-	// Validate file type
-	if !isValidImageType(fileHeader.Header.Get("Content-Type")) {
-		return fmt.Errorf("invalid file type")
-	}
-
-	// Open uploaded file
-	file, err := fileHeader.Open()
-	if err != nil {
-		return fmt.Errorf("failed to open uploaded file: %w", err)
-	}
-	defer file.Close()
-
-	// Create unique filename if file already exists
-	filename := fileHeader.Filename
-	filePath := filepath.Join(s.uploadDir, filename)
-	counter := 1
-	for {
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			break
-		}
-		// File exists, create new name
-		ext := filepath.Ext(fileHeader.Filename)
-		name := strings.TrimSuffix(fileHeader.Filename, ext)
-		filename = fmt.Sprintf("%s_%d%s", name, counter, ext)
-		filePath = filepath.Join(s.uploadDir, filename)
-		counter++
-	}
-
-	// Create destination file
-	dst, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
-	}
-	defer dst.Close()
-
-	// Copy file data
-	if _, err := io.Copy(dst, file); err != nil {
-		os.Remove(filePath) // Clean up on error
-		return fmt.Errorf("failed to copy file data: %w", err)
-	}
-
-	// Get file info
-	fileInfo, err := dst.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
-	}
-
-	// Save to database
-	_, err = s.db.CreateImageFile(filename, fileInfo.Size(), fileHeader.Header.Get("Content-Type"))
-	if err != nil {
-		os.Remove(filePath) // Clean up on error
-		return fmt.Errorf("failed to save to database: %w", err)
-	}
-
-	return nil
+	_, err := uploads.Save(s.db, s.uploadDir, fileHeader)
+	return err
 }
 
 func (s *Server) HandleImageRename(w http.ResponseWriter, r *http.Request) {
@@ -382,8 +531,8 @@ func (s *Server) HandleImageRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if new filename already exists
-	if _, err := os.Stat(newPath); err == nil {
+	// Check if new filename already exists, including in the trash
+	if uploads.Exists(s.uploadDir, newFilename) {
 		http.Redirect(w, r, "/admin/images?error=File with new name already exists", http.StatusSeeOther)
 		return
 	}
@@ -404,6 +553,10 @@ func (s *Server) HandleImageRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := uploads.RenameThumbnail(s.uploadDir, oldFilename, newFilename); err != nil {
+		log.Printf("Error renaming thumbnail: %v", err)
+	}
+
 	http.Redirect(w, r, "/admin/images?success=Image renamed successfully", http.StatusSeeOther)
 }
 
@@ -419,23 +572,18 @@ func (s *Server) HandleImageDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := filepath.Join(s.uploadDir, filename)
-
-	// Delete from database first
-	if err := s.db.DeleteImageFile(filename); err != nil {
-		log.Printf("Error deleting from database: %v", err)
-		http.Redirect(w, r, "/admin/images?error=Failed to delete from database", http.StatusSeeOther)
+	trashed, err := uploads.Trash(s.db, s.uploadDir, filename)
+	if err != nil {
+		log.Printf("Error moving image to trash: %v", err)
+		http.Redirect(w, r, "/admin/images?error=Failed to delete image", http.StatusSeeOther)
 		return
 	}
-
-	// Delete file from filesystem
-	if err := os.Remove(filePath); err != nil {
-		log.Printf("Error deleting file: %v", err)
-		// File deletion failed, but database was updated - this is a partial failure
-		// Could recreate DB entry here, but for simplicity we'll just log it
+	if !trashed {
+		http.Redirect(w, r, "/admin/images?error=Image not found", http.StatusSeeOther)
+		return
 	}
 
-	http.Redirect(w, r, "/admin/images?success=Image deleted successfully", http.StatusSeeOther)
+	http.Redirect(w, r, "/admin/images?success=Image moved to trash", http.StatusSeeOther)
 }
 
 func (s *Server) HandleToggleImage(w http.ResponseWriter, r *http.Request) {
@@ -468,6 +616,93 @@ func (s *Server) HandleToggleImage(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("/admin/images?success=Image %s successfully", action), http.StatusSeeOther)
 }
 
+// HandleImageMetadata saves the title, alt text and credit of an image.
+func (s *Server) HandleImageMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := r.FormValue("filename")
+	if filename == "" {
+		http.Redirect(w, r, "/admin/images?error=Invalid filename", http.StatusSeeOther)
+		return
+	}
+
+	meta, errMsg := parseImageMetadata(r)
+	if errMsg != "" {
+		http.Redirect(w, r, "/admin/images?error="+errMsg, http.StatusSeeOther)
+		return
+	}
+
+	img, err := s.db.GetImageFileByFilename(filename)
+	if err != nil {
+		log.Printf("Error getting image: %v", err)
+		http.Redirect(w, r, "/admin/images?error=Failed to update image details", http.StatusSeeOther)
+		return
+	}
+	if img == nil {
+		http.Redirect(w, r, "/admin/images?error=Image not found", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateImageMetadata(filename, meta); err != nil {
+		log.Printf("Error updating image metadata: %v", err)
+		http.Redirect(w, r, "/admin/images?error=Failed to update image details", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/images?success=Image details updated", http.StatusSeeOther)
+}
+
+// HandleImageSchedule sets when an image starts and stops being served.
+func (s *Server) HandleImageSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := r.FormValue("filename")
+	if filename == "" {
+		http.Redirect(w, r, "/admin/images?error=Invalid filename", http.StatusSeeOther)
+		return
+	}
+
+	publishAt, errMsg := parseScheduleTime(r.FormValue("publish_at"))
+	if errMsg != "" {
+		http.Redirect(w, r, "/admin/images?error=Invalid publish time", http.StatusSeeOther)
+		return
+	}
+	unpublishAt, errMsg := parseScheduleTime(r.FormValue("unpublish_at"))
+	if errMsg != "" {
+		http.Redirect(w, r, "/admin/images?error=Invalid unpublish time", http.StatusSeeOther)
+		return
+	}
+	if publishAt != nil && unpublishAt != nil && !unpublishAt.After(*publishAt) {
+		http.Redirect(w, r, "/admin/images?error=Unpublish time must be after the publish time", http.StatusSeeOther)
+		return
+	}
+
+	img, err := s.db.GetImageFileByFilename(filename)
+	if err != nil {
+		log.Printf("Error getting image: %v", err)
+		http.Redirect(w, r, "/admin/images?error=Failed to update schedule", http.StatusSeeOther)
+		return
+	}
+	if img == nil {
+		http.Redirect(w, r, "/admin/images?error=Image not found", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateImageSchedule(filename, publishAt, unpublishAt); err != nil {
+		log.Printf("Error updating image schedule: %v", err)
+		http.Redirect(w, r, "/admin/images?error=Failed to update schedule", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/images?success=Schedule updated", http.StatusSeeOther)
+}
+
 // Helper functions
 func (s *Server) renderTemplate(w http.ResponseWriter, templateName string, data interface{}) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -475,6 +710,7 @@ func (s *Server) renderTemplate(w http.ResponseWriter, templateName string, data
 	// Parse base template and the specific page template
 	tmpl := template.New("").Funcs(template.FuncMap{
 		"formatFileSize": formatFileSize,
+		"join":           strings.Join,
 		"formatTime": func(t time.Time) string {
 			return t.Format("Jan 2, 2006 3:04 PM")
 		},
@@ -495,21 +731,99 @@ func (s *Server) renderTemplate(w http.ResponseWriter, templateName string, data
 	}
 }
 
-func isValidImageType(contentType string) bool {
-	validTypes := []string{
-		"image/jpeg",
-		"image/jpg",
-		"image/png",
-		"image/gif",
-		"image/webp",
+// ScopeOption describes an API key scope checkbox in the admin forms.
+type ScopeOption struct {
+	Name        string
+	Description string
+	Checked     bool
+}
+
+var scopeDescriptions = map[string]string{
+	models.ScopeImagesRead:  "Fetch random images and serve image files",
+	models.ScopeImagesWrite: "Upload and delete images",
+	models.ScopeKeysManage:  "List, create and delete API keys",
+	models.ScopeStatsRead:   "Read library and usage statistics",
+}
+
+func scopeOptions(selected []string) []ScopeOption {
+	options := make([]ScopeOption, len(models.AllScopes))
+	for i, scope := range models.AllScopes {
+		checked := false
+		for _, s := range selected {
+			if s == scope {
+				checked = true
+				break
+			}
+		}
+		options[i] = ScopeOption{
+			Name:        scope,
+			Description: scopeDescriptions[scope],
+			Checked:     checked,
+		}
 	}
-	
-	for _, validType := range validTypes {
-		if contentType == validType {
-			return true
+	return options
+}
+
+// parseScopes reads the "scopes" form values, returning an error message if
+// none are selected or any are unknown.
+func parseScopes(r *http.Request) ([]string, string) {
+	if err := r.ParseForm(); err != nil {
+		return nil, "Invalid form data"
+	}
+
+	scopes := r.Form["scopes"]
+	if len(scopes) == 0 {
+		return nil, "Select at least one scope"
+	}
+	for _, scope := range scopes {
+		if !models.IsValidScope(scope) {
+			return nil, "Unknown scope: " + scope
+		}
+	}
+	return scopes, ""
+}
+
+// Longest accepted values of the image metadata fields
+const (
+	maxImageTitleLength       = 200
+	maxImageAltTextLength     = 1000
+	maxImageAttributionLength = 200
+	maxImageSourceURLLength   = 2000
+	maxImageLicenseLength     = 100
+)
+
+// parseImageMetadata reads the image details form, returning an error
+// message if a field is invalid.
+func parseImageMetadata(r *http.Request) (models.ImageMetadata, string) {
+	meta := models.ImageMetadata{
+		Title:       strings.TrimSpace(r.FormValue("title")),
+		AltText:     strings.TrimSpace(r.FormValue("alt_text")),
+		Attribution: strings.TrimSpace(r.FormValue("attribution")),
+		SourceURL:   strings.TrimSpace(r.FormValue("source_url")),
+		License:     strings.TrimSpace(r.FormValue("license")),
+	}
+
+	switch {
+	case len(meta.Title) > maxImageTitleLength:
+		return meta, "Title is too long"
+	case len(meta.AltText) > maxImageAltTextLength:
+		return meta, "Alt text is too long"
+	case len(meta.Attribution) > maxImageAttributionLength:
+		return meta, "Attribution is too long"
+	case len(meta.SourceURL) > maxImageSourceURLLength:
+		return meta, "Source URL is too long"
+	case len(meta.License) > maxImageLicenseLength:
+		return meta, "License is too long"
+	}
+
+	if meta.SourceURL != "" {
+		u, err := url.Parse(meta.SourceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return meta, "Source URL must be an http or https URL"
 		}
 	}
-	return false
+
+	return meta, ""
 }
 
 func isValidFilename(filename string) bool {
@@ -545,16 +859,24 @@ func (s *Server) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
 		CreatedAtFormatted string
 		LastUsedFormatted  string
 		RequestCount       int
+		RateLimitFormatted string
+		QuotaFormatted     string
+		ExpiresAtFormatted string
+		ExpiresAtDate      string
+		ExpiringSoon       bool
+		GraceUntil         string
+		Restrictions       []string
+	}
+
+	usage, err := s.db.GetAllTimeUsageByKey()
+	if err != nil {
+		log.Printf("Error getting usage by key: %v", err)
+		usage = map[int]models.UsageCounts{}
 	}
 
 	displayKeys := make([]APIKeyDisplay, len(apiKeys))
+	var expiringKeys []string
 	for i, key := range apiKeys {
-		requestCount, err := s.db.GetAPIKeyUsageCount(key.ID)
-		if err != nil {
-			log.Printf("Error getting usage count for key %d: %v", key.ID, err)
-			requestCount = 0
-		}
-
 		lastUsedFormatted := "Never"
 		if key.LastUsed != nil {
 			lastUsedFormatted = key.LastUsed.Format("Jan 2, 2006 3:04 PM")
@@ -564,23 +886,52 @@ func (s *Server) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
 			APIKey:             key,
 			CreatedAtFormatted: key.CreatedAt.Format("Jan 2, 2006 3:04 PM"),
 			LastUsedFormatted:  lastUsedFormatted,
-			RequestCount:       requestCount,
+			RequestCount:       usage[key.ID].Requests,
+			RateLimitFormatted: formatRateLimit(key),
+			QuotaFormatted:     formatQuota(key),
+			ExpiringSoon:       key.ExpiresWithin(expiryWarningPeriod),
+		}
+
+		if key.ExpiresAt != nil {
+			displayKeys[i].ExpiresAtFormatted = key.ExpiresAt.Format("Jan 2, 2006")
+			displayKeys[i].ExpiresAtDate = key.ExpiresAt.UTC().Format("2006-01-02")
+		}
+		displayKeys[i].Restrictions = describeRestrictions(key)
+		if key.InGracePeriod() {
+			displayKeys[i].GraceUntil = key.PreviousKeyExpiresAt.Format("Jan 2, 2006 3:04 PM")
 		}
+		if displayKeys[i].ExpiringSoon && key.Enabled {
+			expiringKeys = append(expiringKeys, key.Name)
+		}
+	}
+
+	images, err := s.db.GetAllImageFiles()
+	if err != nil {
+		log.Printf("Error getting images: %v", err)
+		images = []*models.ImageFile{}
 	}
 
 	data := struct {
 		PageData
-		APIKeys []APIKeyDisplay
+		APIKeys      []APIKeyDisplay
+		Scopes       []ScopeOption
+		ExpiringKeys []string
+		Images       []*models.ImageFile
 	}{
 		PageData: PageData{
 			Title:      "API Keys",
 			ShowNav:    true,
 			ActivePage: "api-keys",
 			Username:   user.Username,
+			Role:       user.Role,
 			Success:    r.URL.Query().Get("success"),
 			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
 		},
-		APIKeys: displayKeys,
+		APIKeys:      displayKeys,
+		Scopes:       scopeOptions(nil),
+		ExpiringKeys: expiringKeys,
+		Images:       images,
 	}
 
 	s.renderTemplate(w, "api-keys.html", data)
@@ -591,43 +942,89 @@ func (s *Server) HandleNewAPIKey(w http.ResponseWriter, r *http.Request) {
 	
 	data := struct {
 		PageData
-		Name      string
-		NewAPIKey string
+		Name          string
+		KeyID         int
+		NewAPIKey     string
+		SigningSecret string
+		Rotated       bool
+		GraceUntil    string
+		ExpiresAt     string
+		Scopes        []ScopeOption
 	}{
 		PageData: PageData{
 			Title:      "Create API Key",
 			ShowNav:    true,
 			ActivePage: "api-keys",
 			Username:   user.Username,
+			Role:       user.Role,
 			BaseURL:    s.baseURL,
+			CSRFToken:  s.authService.CSRFToken(w, r),
 		},
+		Scopes: scopeOptions([]string{models.ScopeImagesRead}),
 	}
 
 	if r.Method == http.MethodPost {
 		name := strings.TrimSpace(r.FormValue("name"))
+		scopes, scopeErr := parseScopes(r)
+		expiresAt, expiryErr := parseExpiryDate(r.FormValue("expires_at"))
 		
 		if name == "" {
 			data.Error = "API key name is required"
 		} else if len(name) > 100 {
 			data.Error = "API key name must be 100 characters or less"
+		} else if scopeErr != "" {
+			data.Error = scopeErr
+		} else if expiryErr != "" {
+			data.Error = expiryErr
 		} else {
-			apiKey, rawKey, err := s.db.CreateAPIKey(name)
+			apiKey, rawKey, err := s.db.CreateAPIKey(name, scopes, expiresAt)
 			if err != nil {
 				log.Printf("Error creating API key: %v", err)
 				data.Error = "Failed to create API key"
 			} else {
 				log.Printf("Created API key: %s (ID: %d)", apiKey.Name, apiKey.ID)
+				data.KeyID = apiKey.ID
 				data.NewAPIKey = rawKey
+				data.SigningSecret = s.authService.SigningSecret(rawKey)
 				s.renderTemplate(w, "new-api-key.html", data)
 				return
 			}
 		}
 		data.Name = name
+		data.ExpiresAt = r.FormValue("expires_at")
+		data.Scopes = scopeOptions(scopes)
 	}
 
 	s.renderTemplate(w, "new-api-key.html", data)
 }
 
+func (s *Server) HandleAPIKeyScopes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.FormValue("key_id"))
+	if err != nil {
+		http.Redirect(w, r, "/admin/api-keys?error=Invalid key ID", http.StatusSeeOther)
+		return
+	}
+
+	scopes, scopeErr := parseScopes(r)
+	if scopeErr != "" {
+		http.Redirect(w, r, "/admin/api-keys?error="+scopeErr, http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateAPIKeyScopes(keyID, scopes); err != nil {
+		log.Printf("Error updating API key scopes: %v", err)
+		http.Redirect(w, r, "/admin/api-keys?error=Failed to update API key scopes", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/api-keys?success=API key scopes updated successfully", http.StatusSeeOther)
+}
+
 func (s *Server) HandleToggleAPIKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -659,12 +1056,103 @@ func (s *Server) HandleToggleAPIKey(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("/admin/api-keys?success=API key %s successfully", action), http.StatusSeeOther)
 }
 
+func (s *Server) HandleAPIKeyRestrictions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.FormValue("key_id"))
+	if err != nil {
+		http.Redirect(w, r, "/admin/api-keys?error=Invalid key ID", http.StatusSeeOther)
+		return
+	}
+
+	origins := splitLines(r.FormValue("allowed_origins"))
+	referrers := splitLines(r.FormValue("allowed_referrers"))
+	ips := splitLines(r.FormValue("allowed_ips"))
+
+	if err := auth.ValidateOrigins(origins); err != nil {
+		http.Redirect(w, r, "/admin/api-keys?error="+err.Error(), http.StatusSeeOther)
+		return
+	}
+	if _, err := auth.ParseIPAllowlist(ips); err != nil {
+		http.Redirect(w, r, "/admin/api-keys?error="+err.Error(), http.StatusSeeOther)
+		return
+	}
+
+	var imageIDs []int
+	for _, value := range r.Form["image_ids"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Redirect(w, r, "/admin/api-keys?error=Invalid image selection", http.StatusSeeOther)
+			return
+		}
+		imageIDs = append(imageIDs, id)
+	}
+
+	if err := s.db.UpdateAPIKeyRestrictions(keyID, origins, referrers, ips, imageIDs); err != nil {
+		log.Printf("Error updating API key restrictions: %v", err)
+		http.Redirect(w, r, "/admin/api-keys?error=Failed to update API key restrictions", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/api-keys?success=API key restrictions updated successfully", http.StatusSeeOther)
+}
+
+func (s *Server) HandleAPIKeyLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.FormValue("key_id"))
+	if err != nil {
+		http.Redirect(w, r, "/admin/api-keys?error=Invalid key ID", http.StatusSeeOther)
+		return
+	}
+
+	ratePerSecond, err := strconv.ParseFloat(defaultString(r.FormValue("rate_limit_per_second"), "0"), 64)
+	if err != nil || ratePerSecond < 0 {
+		http.Redirect(w, r, "/admin/api-keys?error=Requests per second must be zero or a positive number", http.StatusSeeOther)
+		return
+	}
+
+	var limits [3]int
+	fields := []struct {
+		name  string
+		label string
+	}{
+		{"rate_limit_burst", "Burst"},
+		{"daily_image_quota", "Daily quota"},
+		{"monthly_image_quota", "Monthly quota"},
+	}
+	for i, field := range fields {
+		value, err := strconv.Atoi(defaultString(r.FormValue(field.name), "0"))
+		if err != nil || value < 0 {
+			http.Redirect(w, r, "/admin/api-keys?error="+field.label+" must be zero or a positive whole number", http.StatusSeeOther)
+			return
+		}
+		limits[i] = value
+	}
+
+	if err := s.db.UpdateAPIKeyLimits(keyID, ratePerSecond, limits[0], limits[1], limits[2]); err != nil {
+		log.Printf("Error updating API key limits: %v", err)
+		http.Redirect(w, r, "/admin/api-keys?error=Failed to update API key limits", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/api-keys?success=API key limits updated successfully", http.StatusSeeOther)
+}
+
 func (s *Server) HandleRegenerateAPIKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	user := auth.GetAdminFromContext(r.Context())
+
 	keyIDStr := r.FormValue("key_id")
 	keyID, err := strconv.Atoi(keyIDStr)
 	if err != nil {
@@ -672,20 +1160,17 @@ func (s *Server) HandleRegenerateAPIKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get existing key info
-	keys, err := s.db.GetAllAPIKeys()
-	if err != nil {
-		log.Printf("Error getting API keys: %v", err)
-		http.Redirect(w, r, "/admin/api-keys?error=Failed to regenerate API key", http.StatusSeeOther)
+	graceHours, err := strconv.Atoi(defaultString(r.FormValue("grace_hours"), "0"))
+	if err != nil || graceHours < 0 {
+		http.Redirect(w, r, "/admin/api-keys?error=Grace period must be zero or a positive number of hours", http.StatusSeeOther)
 		return
 	}
 
-	var existingKey *models.APIKey
-	for _, key := range keys {
-		if key.ID == keyID {
-			existingKey = key
-			break
-		}
+	existingKey, err := s.db.GetAPIKeyByID(keyID)
+	if err != nil {
+		log.Printf("Error getting API key: %v", err)
+		http.Redirect(w, r, "/admin/api-keys?error=Failed to regenerate API key", http.StatusSeeOther)
+		return
 	}
 
 	if existingKey == nil {
@@ -693,22 +1178,74 @@ func (s *Server) HandleRegenerateAPIKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Delete old key and create new one with same name
-	if err := s.db.DeleteAPIKey(keyID); err != nil {
-		log.Printf("Error deleting old API key: %v", err)
+	// Issue a new secret for the same key, keeping the old one valid during the grace period
+	gracePeriod := time.Duration(graceHours) * time.Hour
+	rawKey, err := s.db.RotateAPIKey(keyID, gracePeriod)
+	if err != nil {
+		log.Printf("Error rotating API key: %v", err)
 		http.Redirect(w, r, "/admin/api-keys?error=Failed to regenerate API key", http.StatusSeeOther)
 		return
 	}
 
-	newKey, _, err := s.db.CreateAPIKey(existingKey.Name)
+	log.Printf("Rotated API key: %s (ID: %d, grace period: %s)", existingKey.Name, keyID, gracePeriod)
+
+	data := struct {
+		PageData
+		Name          string
+		KeyID         int
+		NewAPIKey     string
+		SigningSecret string
+		Rotated       bool
+		GraceUntil    string
+		Scopes        []ScopeOption
+	}{
+		PageData: PageData{
+			Title:      "Regenerate API Key",
+			ShowNav:    true,
+			ActivePage: "api-keys",
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		Name:          existingKey.Name,
+		KeyID:         keyID,
+		NewAPIKey:     rawKey,
+		SigningSecret: s.authService.SigningSecret(rawKey),
+		Rotated:       true,
+	}
+	if gracePeriod > 0 {
+		data.GraceUntil = time.Now().Add(gracePeriod).Format("Jan 2, 2006 3:04 PM")
+	}
+
+	s.renderTemplate(w, "new-api-key.html", data)
+}
+
+func (s *Server) HandleAPIKeyExpiry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.FormValue("key_id"))
 	if err != nil {
-		log.Printf("Error creating new API key: %v", err)
-		http.Redirect(w, r, "/admin/api-keys?error=Failed to regenerate API key", http.StatusSeeOther)
+		http.Redirect(w, r, "/admin/api-keys?error=Invalid key ID", http.StatusSeeOther)
 		return
 	}
 
-	log.Printf("Regenerated API key: %s (old ID: %d, new ID: %d)", newKey.Name, keyID, newKey.ID)
-	http.Redirect(w, r, "/admin/api-keys?success=API key regenerated successfully", http.StatusSeeOther)
+	expiresAt, errMsg := parseExpiryDate(r.FormValue("expires_at"))
+	if errMsg != "" {
+		http.Redirect(w, r, "/admin/api-keys?error="+errMsg, http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateAPIKeyExpiry(keyID, expiresAt); err != nil {
+		log.Printf("Error updating API key expiry: %v", err)
+		http.Redirect(w, r, "/admin/api-keys?error=Failed to update API key expiry", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/api-keys?success=API key expiry updated successfully", http.StatusSeeOther)
 }
 
 func (s *Server) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
@@ -740,20 +1277,33 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 	
 	data := struct {
 		PageData
-		RequireAPIKeyForImages bool
-		DefaultImageCount      string
-		MaxImageCount          string
-		CORSEnabled            bool
-		CORSOrigins            string
+		RequireAPIKeyForImages     bool
+		DefaultImageCount          string
+		MaxImageCount              string
+		CORSEnabled                bool
+		CORSOrigins                string
+		SignImageURLs              bool
+		SignedURLTTLSeconds        string
+		SigningSecrets             []*models.SigningSecret
+		RequireTwoFactor           bool
+		SessionIdleTimeout         string
+		SessionLifetime            string
+		TrashRetentionDays         string
+		RequestLogRetentionDays    string
+		RequestLogEntries          int
+		MinRequestLogRetentionDays int
 	}{
 		PageData: PageData{
 			Title:      "Settings",
 			ShowNav:    true,
 			ActivePage: "settings",
 			Username:   user.Username,
+			Role:       user.Role,
 			Success:    r.URL.Query().Get("success"),
 			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
 		},
+		MinRequestLogRetentionDays: analytics.MinRequestLogRetentionDays,
 	}
 
 	if r.Method == http.MethodPost {
@@ -763,6 +1313,13 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 		maxImageCount := r.FormValue("max_image_count")
 		corsEnabled := r.FormValue("cors_enabled") == "on"
 		corsOrigins := r.FormValue("cors_origins")
+		signImageURLs := r.FormValue("sign_image_urls") == "on"
+		signedURLTTL := r.FormValue("signed_url_ttl_seconds")
+		requireTwoFactor := r.FormValue("require_2fa") == "on"
+		sessionIdleTimeout := r.FormValue("session_idle_timeout_minutes")
+		sessionLifetime := r.FormValue("session_lifetime_hours")
+		trashRetention := r.FormValue("trash_retention_days")
+		requestLogRetention := r.FormValue("request_log_retention_days")
 
 		// Validate input
 		if defaultImageCount == "" {
@@ -774,6 +1331,21 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 		if corsOrigins == "" {
 			corsOrigins = "*"
 		}
+		if signedURLTTL == "" {
+			signedURLTTL = "3600"
+		}
+		if sessionIdleTimeout == "" {
+			sessionIdleTimeout = "60"
+		}
+		if sessionLifetime == "" {
+			sessionLifetime = "24"
+		}
+		if trashRetention == "" {
+			trashRetention = "30"
+		}
+		if requestLogRetention == "" {
+			requestLogRetention = "90"
+		}
 
 		// Validate numeric values
 		if defaultCount, err := strconv.Atoi(defaultImageCount); err != nil || defaultCount < 1 {
@@ -782,6 +1354,16 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 			data.Error = "Maximum image count must be a positive number"
 		} else if defaultCount > maxCount {
 			data.Error = "Default image count cannot be greater than maximum image count"
+		} else if ttl, err := strconv.Atoi(signedURLTTL); err != nil || ttl < 1 {
+			data.Error = "Signed URL lifetime must be a positive number of seconds"
+		} else if idle, err := strconv.Atoi(sessionIdleTimeout); err != nil || idle < 1 {
+			data.Error = "Session idle timeout must be a positive number of minutes"
+		} else if lifetime, err := strconv.Atoi(sessionLifetime); err != nil || lifetime < 1 {
+			data.Error = "Session lifetime must be a positive number of hours"
+		} else if days, err := strconv.Atoi(trashRetention); err != nil || days < 0 {
+			data.Error = "Trash retention must be a number of days, or 0 to keep deleted images until the trash is emptied"
+		} else if days, err := strconv.Atoi(requestLogRetention); err != nil || days < analytics.MinRequestLogRetentionDays {
+			data.Error = fmt.Sprintf("Request log retention must be at least %d days", analytics.MinRequestLogRetentionDays)
 		} else {
 			// Save settings
 			settingsToSave := map[string]string{
@@ -790,6 +1372,13 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 				"max_image_count":           maxImageCount,
 				"cors_enabled":              fmt.Sprintf("%t", corsEnabled),
 				"cors_origins":              corsOrigins,
+				"sign_image_urls":           fmt.Sprintf("%t", signImageURLs),
+				"signed_url_ttl_seconds":    signedURLTTL,
+				"require_2fa":               fmt.Sprintf("%t", requireTwoFactor),
+				"session_idle_timeout_minutes": sessionIdleTimeout,
+				"session_lifetime_hours":       sessionLifetime,
+				"trash_retention_days":         trashRetention,
+				"request_log_retention_days":   requestLogRetention,
 			}
 
 			var saveError bool
@@ -814,6 +1403,13 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 		data.MaxImageCount = maxImageCount
 		data.CORSEnabled = corsEnabled
 		data.CORSOrigins = corsOrigins
+		data.SignImageURLs = signImageURLs
+		data.SignedURLTTLSeconds = signedURLTTL
+		data.RequireTwoFactor = requireTwoFactor
+		data.SessionIdleTimeout = sessionIdleTimeout
+		data.SessionLifetime = sessionLifetime
+		data.TrashRetentionDays = trashRetention
+		data.RequestLogRetentionDays = requestLogRetention
 	} else {
 		// Load current settings
 		if val, err := s.db.GetSetting("require_api_key_for_images"); err == nil {
@@ -831,11 +1427,75 @@ func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
 		if val, err := s.db.GetSetting("cors_origins"); err == nil {
 			data.CORSOrigins = val
 		}
+		if val, err := s.db.GetSetting("sign_image_urls"); err == nil {
+			data.SignImageURLs = val == "true"
+		}
+		if val, err := s.db.GetSetting("signed_url_ttl_seconds"); err == nil {
+			data.SignedURLTTLSeconds = val
+		}
+		data.RequireTwoFactor = s.authService.TwoFactorRequired()
+		timeouts := s.authService.SessionTimeouts()
+		data.SessionIdleTimeout = strconv.Itoa(int(timeouts.Idle.Minutes()))
+		data.SessionLifetime = strconv.Itoa(int(timeouts.Lifetime.Hours()))
+		data.TrashRetentionDays = strconv.Itoa(uploads.TrashRetentionDays(s.db))
+		data.RequestLogRetentionDays = strconv.Itoa(analytics.RequestLogRetentionDays(s.db))
+	}
+	data.SigningSecrets = s.signer.Secrets()
+	if count, err := s.db.GetTotalAPIRequestCount(); err == nil {
+		data.RequestLogEntries = count
+	} else {
+		log.Printf("Error getting request log size: %v", err)
 	}
 
 	s.renderTemplate(w, "settings.html", data)
 }
 
+// HandleRotateSigningSecret issues a new URL signing secret. The previous
+// secret keeps verifying for one signed URL lifetime so issued URLs stay valid.
+func (s *Server) HandleRotateSigningSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttlStr, err := s.db.GetSetting("signed_url_ttl_seconds")
+	if err != nil || ttlStr == "" {
+		ttlStr = "3600"
+	}
+	ttl, err := strconv.Atoi(ttlStr)
+	if err != nil || ttl < 1 {
+		ttl = 3600
+	}
+
+	if err := s.signer.Rotate(time.Duration(ttl) * time.Second); err != nil {
+		log.Printf("Error rotating signing secret: %v", err)
+		http.Redirect(w, r, "/admin/settings?error=Failed to rotate signing secret", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Rotated URL signing secret")
+	http.Redirect(w, r, "/admin/settings?success=Signing secret rotated successfully", http.StatusSeeOther)
+}
+
+// HandleCompactRequestLog deletes API request log entries older than the
+// retention setting now, instead of waiting for the periodic compaction.
+func (s *Server) HandleCompactRequestLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deleted, err := analytics.CompactRequestLog(s.db)
+	if err != nil {
+		log.Printf("Error compacting request log: %v", err)
+		http.Redirect(w, r, "/admin/settings?error=Failed to compact the request log", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Deleted %d API request log entries", deleted)
+	http.Redirect(w, r, fmt.Sprintf("/admin/settings?success=Deleted %d request log entries", deleted), http.StatusSeeOther)
+}
+
 // HandleServeImage serves images for the admin interface without API restrictions
 func (s *Server) HandleServeImage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -903,6 +1563,106 @@ func (s *Server) HandleServeImage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filePath)
 }
 
+func formatRateLimit(key *models.APIKey) string {
+	if key.RateLimitPerSecond <= 0 {
+		return "Unlimited"
+	}
+	rate := strconv.FormatFloat(key.RateLimitPerSecond, 'f', -1, 64) + "/s"
+	if key.RateLimitBurst > 0 {
+		rate += fmt.Sprintf(" (burst %d)", key.RateLimitBurst)
+	}
+	return rate
+}
+
+func formatQuota(key *models.APIKey) string {
+	var parts []string
+	if key.DailyImageQuota > 0 {
+		parts = append(parts, fmt.Sprintf("%d/day", key.DailyImageQuota))
+	}
+	if key.MonthlyImageQuota > 0 {
+		parts = append(parts, fmt.Sprintf("%d/month", key.MonthlyImageQuota))
+	}
+	if len(parts) == 0 {
+		return "Unlimited"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// expiryWarningPeriod is how far ahead the API keys page warns about expiring keys.
+const expiryWarningPeriod = 7 * 24 * time.Hour
+
+// parseExpiryDate parses an optional YYYY-MM-DD date. Keys expire at the start
+// of that day (UTC). An empty value means the key never expires.
+func parseExpiryDate(value string) (*time.Time, string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, ""
+	}
+
+	expiresAt, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, "Invalid expiry date"
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, "Expiry date must be in the future"
+	}
+	return &expiresAt, ""
+}
+
+// Format of the datetime-local inputs in the image schedule form, in UTC
+const scheduleInputLayout = "2006-01-02T15:04"
+
+// parseScheduleTime parses a schedule form time. An empty value means no
+// time.
+func parseScheduleTime(value string) (*time.Time, string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, ""
+	}
+
+	t, err := time.Parse(scheduleInputLayout, value)
+	if err != nil {
+		return nil, "Invalid time"
+	}
+	return &t, ""
+}
+
+func describeRestrictions(key *models.APIKey) []string {
+	var restrictions []string
+	if len(key.AllowedOrigins) > 0 {
+		restrictions = append(restrictions, fmt.Sprintf("%d origin(s)", len(key.AllowedOrigins)))
+	}
+	if len(key.AllowedReferrers) > 0 {
+		restrictions = append(restrictions, fmt.Sprintf("%d referrer(s)", len(key.AllowedReferrers)))
+	}
+	if len(key.AllowedIPs) > 0 {
+		restrictions = append(restrictions, fmt.Sprintf("%d IP range(s)", len(key.AllowedIPs)))
+	}
+	if len(key.AllowedImageIDs) > 0 {
+		restrictions = append(restrictions, fmt.Sprintf("%d image(s)", len(key.AllowedImageIDs)))
+	}
+	return restrictions
+}
+
+// splitLines splits textarea input into trimmed, non-empty lines.
+func splitLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func defaultString(value, fallback string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return strings.TrimSpace(value)
+}
+
 func formatFileSize(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
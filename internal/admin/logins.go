@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"time"
+)
+
+const loginAttemptsPageSize = 200
+
+// HandleLoginAttempts lists recent admin sign-in attempts and current lockouts.
+func (s *Server) HandleLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAdminFromContext(r.Context())
+
+	attempts, err := s.db.GetRecentLoginAttempts(loginAttemptsPageSize)
+	if err != nil {
+		log.Printf("Error getting login attempts: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	lockouts, err := s.authService.GetLockouts()
+	if err != nil {
+		log.Printf("Error getting lockouts: %v", err)
+		lockouts = nil
+	}
+
+	data := struct {
+		PageData
+		Attempts []*models.LoginAttempt
+		Lockouts []auth.Lockout
+	}{
+		PageData: PageData{
+			Title:      "Login Activity",
+			ShowNav:    true,
+			ActivePage: "users",
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			Success:    r.URL.Query().Get("success"),
+			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		Attempts: attempts,
+		Lockouts: lockouts,
+	}
+
+	s.renderTemplate(w, "login-attempts.html", data)
+}
+
+// HandleUnlockLogin lifts a lockout on a username or IP address before it expires.
+func (s *Server) HandleUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+	ipAddress := r.FormValue("ip_address")
+	if (username == "") == (ipAddress == "") {
+		http.Redirect(w, r, "/admin/users/logins?error=Choose a username or IP address to unlock", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.authService.Unlock(username, ipAddress); err != nil {
+		log.Printf("Error unlocking login: %v", err)
+		http.Redirect(w, r, "/admin/users/logins?error=Failed to unlock", http.StatusSeeOther)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	log.Printf("Admin user %s unlocked sign-in for %s%s", user.Username, username, ipAddress)
+	http.Redirect(w, r, "/admin/users/logins?success=Sign-in unlocked", http.StatusSeeOther)
+}
+
+// tooManyAttemptsMessage tells a throttled client how long to wait.
+func tooManyAttemptsMessage(wait time.Duration) string {
+	return "Too many failed sign-in attempts. Try again in " + formatWait(wait) + "."
+}
+
+func formatWait(wait time.Duration) string {
+	if wait < time.Minute {
+		seconds := int(math.Ceil(wait.Seconds()))
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int(math.Ceil(wait.Minutes()))
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
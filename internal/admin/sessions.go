@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"strconv"
+)
+
+// HandleSessions lists the signed-in admin's active sessions.
+func (s *Server) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAdminFromContext(r.Context())
+	s.renderSessions(w, r, user.ID, "account")
+}
+
+// HandleAllSessions lists the active sessions of every admin.
+func (s *Server) HandleAllSessions(w http.ResponseWriter, r *http.Request) {
+	s.renderSessions(w, r, 0, "users")
+}
+
+func (s *Server) renderSessions(w http.ResponseWriter, r *http.Request, userID int, activePage string) {
+	user := auth.GetAdminFromContext(r.Context())
+
+	sessions, err := s.authService.GetActiveSessions(userID)
+	if err != nil {
+		log.Printf("Error getting admin sessions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	current := auth.GetSessionFromContext(r.Context())
+	data := struct {
+		PageData
+		Sessions         []*models.AdminSession
+		CurrentSessionID int
+		AllUsers         bool
+	}{
+		PageData: PageData{
+			Title:      "Active Sessions",
+			ShowNav:    true,
+			ActivePage: activePage,
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			Success:    r.URL.Query().Get("success"),
+			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		Sessions:         sessions,
+		CurrentSessionID: current.ID,
+		AllUsers:         userID == 0,
+	}
+
+	s.renderTemplate(w, "sessions.html", data)
+}
+
+// HandleRevokeSession signs out a single session. Admins can revoke their
+// own sessions and owners can revoke anyone's.
+func (s *Server) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	redirect := "/admin/account/sessions"
+	if r.FormValue("all") == "true" && user.HasRole(models.RoleOwner) {
+		redirect = "/admin/users/sessions"
+	}
+
+	sessionID, err := strconv.Atoi(r.FormValue("session_id"))
+	if err != nil {
+		http.Redirect(w, r, redirect+"?error=Invalid session ID", http.StatusSeeOther)
+		return
+	}
+
+	session, err := s.db.GetAdminSessionByID(sessionID)
+	if err != nil {
+		log.Printf("Error getting admin session: %v", err)
+		http.Redirect(w, r, redirect+"?error=Failed to sign out session", http.StatusSeeOther)
+		return
+	}
+	if session == nil || (session.UserID != user.ID && !user.HasRole(models.RoleOwner)) {
+		http.Redirect(w, r, redirect+"?error=Session not found", http.StatusSeeOther)
+		return
+	}
+
+	// Revoking the current session is the same as signing out
+	if current := auth.GetSessionFromContext(r.Context()); current.ID == session.ID {
+		s.HandleLogout(w, r)
+		return
+	}
+
+	if err := s.db.DeleteAdminSession(session.ID); err != nil {
+		log.Printf("Error deleting admin session: %v", err)
+		http.Redirect(w, r, redirect+"?error=Failed to sign out session", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Admin user %s signed out a session of %s", user.Username, session.Username)
+	http.Redirect(w, r, redirect+"?success=Session signed out", http.StatusSeeOther)
+}
+
+// HandleRevokeOtherSessions signs out every session of the signed-in admin
+// except the current one.
+func (s *Server) HandleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	current := auth.GetSessionFromContext(r.Context())
+
+	if err := s.db.DeleteAdminSessionsForUser(user.ID, current.ID); err != nil {
+		log.Printf("Error deleting admin sessions: %v", err)
+		http.Redirect(w, r, "/admin/account/sessions?error=Failed to sign out other sessions", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Admin user %s signed out their other sessions", user.Username)
+	http.Redirect(w, r, "/admin/account/sessions?success=Signed out all other sessions", http.StatusSeeOther)
+}
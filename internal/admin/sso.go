@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"shufflr/internal/auth"
+)
+
+// HandleOIDCLogin sends the browser to the OpenID provider to sign in.
+func (s *Server) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.authService.OIDCEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, err := s.authService.StartOIDCLogin(w, r)
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		data := s.newLoginPage(w, r)
+		data.Error = "Single sign-on is unavailable"
+		s.renderTemplate(w, "login.html", data)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback completes single sign-on when the provider redirects back.
+func (s *Server) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !s.authService.OIDCEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := s.authService.FinishOIDCLogin(w, r)
+	if err == nil {
+		log.Printf("Admin user %s signed in with single sign-on", user.Username)
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	data := s.newLoginPage(w, r)
+	switch {
+	case errors.Is(err, auth.ErrOIDCLoginExpired):
+		data.Error = "Your sign-in took too long or was started in another browser. Try again."
+	case errors.Is(err, auth.ErrOIDCNoRole):
+		data.Error = "Your account is not allowed to access the admin interface"
+	case errors.Is(err, auth.ErrUserDisabled):
+		data.Error = "Your admin account is disabled"
+	case errors.Is(err, auth.ErrOIDCUsernameUsed):
+		data.Error = "A local account with your username already exists. Ask an owner to remove it."
+	default:
+		log.Printf("Error completing single sign-on: %v", err)
+		data.Error = "Single sign-on failed"
+	}
+	s.renderTemplate(w, "login.html", data)
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"shufflr/internal/uploads"
+	"strings"
+)
+
+// HandleServeThumbnail serves the thumbnail of an image for the image grids.
+// Editors can also see the thumbnails of images in the trash.
+func (s *Server) HandleServeThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := filepath.Base(strings.TrimPrefix(r.URL.Path, "/admin/images/thumb/"))
+
+	img, err := s.db.GetImageFileByFilename(filename)
+	if err == nil && img == nil && auth.GetAdminFromContext(r.Context()).HasRole(models.RoleEditor) {
+		img, err = s.db.GetTrashedImageFile(filename)
+	}
+	if err != nil {
+		log.Printf("Error getting image: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if img == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	thumbPath := uploads.ThumbnailPath(s.uploadDir, filename)
+	if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
+		// Not created yet by the backfill, or the image couldn't be decoded
+		// when it was uploaded. Images that fail aren't tried again until
+		// the server restarts.
+		if _, failed := s.failedThumbnails.Load(filename); failed {
+			servePlaceholderThumbnail(w)
+			return
+		}
+		if err := uploads.GenerateThumbnail(s.uploadDir, filename, uploads.ImagePath(s.uploadDir, img)); err != nil {
+			log.Printf("Error creating thumbnail for %s: %v", filename, err)
+			s.failedThumbnails.Store(filename, true)
+			servePlaceholderThumbnail(w)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeFile(w, r, thumbPath)
+}
+
+// placeholderThumbnail stands in for images without a thumbnail, rather
+// than sending the whole original to the image grid.
+const placeholderThumbnail = `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 24 24" fill="none" stroke="#9ca3af" stroke-width="1.5" stroke-linecap="round" stroke-linejoin="round">` +
+	`<rect width="24" height="24" fill="#e5e7eb" stroke="none"/>` +
+	`<rect x="4" y="5" width="16" height="14" rx="2"/><circle cx="9" cy="10" r="1.5"/><path d="M20 16l-5-5-9 8"/></svg>`
+
+func servePlaceholderThumbnail(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write([]byte(placeholderThumbnail))
+}
@@ -0,0 +1,163 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"shufflr/internal/uploads"
+	"strings"
+	"time"
+)
+
+// HandleTrash lists deleted images that can still be restored.
+func (s *Server) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAdminFromContext(r.Context())
+
+	images, err := s.db.GetTrashedImageFiles(time.Time{})
+	if err != nil {
+		log.Printf("Error getting trashed images: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	retentionDays := uploads.TrashRetentionDays(s.db)
+
+	type TrashedImage struct {
+		*models.ImageFile
+		SizeFormatted string
+		// Zero when the trash is never emptied automatically
+		PurgeAt time.Time
+	}
+
+	displayImages := make([]TrashedImage, len(images))
+	var totalSize int64
+	for i, img := range images {
+		totalSize += img.Size
+		displayImages[i] = TrashedImage{
+			ImageFile:     img,
+			SizeFormatted: formatFileSize(img.Size),
+		}
+		if retentionDays > 0 {
+			displayImages[i].PurgeAt = img.DeletedAt.AddDate(0, 0, retentionDays)
+		}
+	}
+
+	data := struct {
+		PageData
+		Images             []TrashedImage
+		TotalSizeFormatted string
+		RetentionDays      int
+	}{
+		PageData: PageData{
+			Title:      "Trash",
+			ShowNav:    true,
+			ActivePage: "images",
+			Username:   user.Username,
+			Role:       user.Role,
+			Success:    r.URL.Query().Get("success"),
+			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		Images:             displayImages,
+		TotalSizeFormatted: formatFileSize(totalSize),
+		RetentionDays:      retentionDays,
+	}
+
+	s.renderTemplate(w, "trash.html", data)
+}
+
+// HandleServeTrashedImage serves an image in the trash for the trash page.
+func (s *Server) HandleServeTrashedImage(w http.ResponseWriter, r *http.Request) {
+	filename := filepath.Base(strings.TrimPrefix(r.URL.Path, "/admin/images/trash/serve/"))
+
+	img, err := s.db.GetTrashedImageFile(filename)
+	if err != nil {
+		log.Printf("Error getting trashed image: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if img == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	filePath := uploads.TrashPath(s.uploadDir, filename)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		http.Error(w, "Image file not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.MimeType)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeFile(w, r, filePath)
+}
+
+// HandleRestoreImage moves an image out of the trash.
+func (s *Server) HandleRestoreImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := uploads.Restore(s.db, s.uploadDir, r.FormValue("filename"))
+	switch {
+	case errors.Is(err, uploads.ErrNotInTrash):
+		http.Redirect(w, r, "/admin/images/trash?error=Image not found in trash", http.StatusSeeOther)
+	case errors.Is(err, uploads.ErrFilenameTaken):
+		http.Redirect(w, r, "/admin/images/trash?error=Another image already uses this filename", http.StatusSeeOther)
+	case err != nil:
+		log.Printf("Error restoring image: %v", err)
+		http.Redirect(w, r, "/admin/images/trash?error=Failed to restore image", http.StatusSeeOther)
+	default:
+		http.Redirect(w, r, "/admin/images/trash?success=Image restored", http.StatusSeeOther)
+	}
+}
+
+// HandlePurgeImage permanently deletes an image in the trash.
+func (s *Server) HandlePurgeImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := uploads.Purge(s.db, s.uploadDir, r.FormValue("filename"))
+	switch {
+	case errors.Is(err, uploads.ErrNotInTrash):
+		http.Redirect(w, r, "/admin/images/trash?error=Image not found in trash", http.StatusSeeOther)
+	case err != nil:
+		log.Printf("Error purging image: %v", err)
+		http.Redirect(w, r, "/admin/images/trash?error=Failed to delete image", http.StatusSeeOther)
+	default:
+		http.Redirect(w, r, "/admin/images/trash?success=Image deleted permanently", http.StatusSeeOther)
+	}
+}
+
+// HandleEmptyTrash permanently deletes every image in the trash.
+func (s *Server) HandleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	images, err := s.db.GetTrashedImageFiles(time.Time{})
+	if err != nil {
+		log.Printf("Error getting trashed images: %v", err)
+		http.Redirect(w, r, "/admin/images/trash?error=Failed to empty trash", http.StatusSeeOther)
+		return
+	}
+
+	for _, img := range images {
+		if err := uploads.Purge(s.db, s.uploadDir, img.Filename); err != nil {
+			log.Printf("Error purging image %s: %v", img.Filename, err)
+			http.Redirect(w, r, "/admin/images/trash?error=Failed to empty trash", http.StatusSeeOther)
+			return
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/images/trash?success=Deleted %d image(s) permanently", len(images)), http.StatusSeeOther)
+}
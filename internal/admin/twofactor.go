@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"shufflr/internal/qrcode"
+	"shufflr/internal/totp"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer        = "Shufflr"
+	recoveryCodeCount = 10
+)
+
+// HandleTwoFactorSetup starts 2FA enrollment by generating a secret and
+// showing it as a QR code. The secret is not enforced until confirmed.
+func (s *Server) HandleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	if user.TOTPEnabled {
+		http.Redirect(w, r, "/admin/account?error=Two-factor authentication is already enabled", http.StatusSeeOther)
+		return
+	}
+	if user.IsSSO() {
+		http.Redirect(w, r, "/admin/account?error=Two-factor authentication is managed by your identity provider", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err == nil {
+		err = s.db.SetAdminUserTOTPSecret(user.ID, secret)
+	}
+	if err != nil {
+		log.Printf("Error starting 2FA enrollment: %v", err)
+		http.Redirect(w, r, "/admin/account?error=Failed to start two-factor setup", http.StatusSeeOther)
+		return
+	}
+
+	user.TOTPSecret = secret
+	s.renderTwoFactorSetup(w, r, user, "")
+}
+
+func (s *Server) renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, user *models.AdminUser, errMsg string) {
+	uri := totp.URI(totpIssuer, user.Username, user.TOTPSecret)
+	code, err := qrcode.Encode([]byte(uri))
+	if err != nil {
+		log.Printf("Error rendering 2FA QR code: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Group the secret in fours for manual entry
+	var groups []string
+	for i := 0; i < len(user.TOTPSecret); i += 4 {
+		groups = append(groups, user.TOTPSecret[i:min(i+4, len(user.TOTPSecret))])
+	}
+
+	data := struct {
+		PageData
+		QRCode template.HTML
+		Secret string
+	}{
+		PageData: PageData{
+			Title:      "Set Up Two-Factor Authentication",
+			ShowNav:    true,
+			ActivePage: "account",
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			Error:      errMsg,
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		// Generated from our own encoder output, so safe to inline
+		QRCode: template.HTML(code.SVG(220)),
+		Secret: strings.Join(groups, " "),
+	}
+
+	s.renderTemplate(w, "two-factor-setup.html", data)
+}
+
+// HandleTwoFactorEnable confirms enrollment with a code from the
+// authenticator app and issues recovery codes.
+func (s *Server) HandleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	if user.TOTPEnabled {
+		http.Redirect(w, r, "/admin/account?error=Two-factor authentication is already enabled", http.StatusSeeOther)
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Redirect(w, r, "/admin/account?error=Start two-factor setup first", http.StatusSeeOther)
+		return
+	}
+
+	counter, ok := totp.Validate(user.TOTPSecret, r.FormValue("code"), time.Now(), 0)
+	if !ok {
+		s.renderTwoFactorSetup(w, r, user, "Invalid code. Check your device's clock and try again.")
+		return
+	}
+
+	if err := s.db.EnableAdminUserTOTP(user.ID, counter); err != nil {
+		log.Printf("Error enabling 2FA: %v", err)
+		http.Redirect(w, r, "/admin/account?error=Failed to enable two-factor authentication", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Admin user %s enabled two-factor authentication", user.Username)
+	s.issueRecoveryCodes(w, r, user, "Two-factor authentication enabled")
+}
+
+// HandleRecoveryCodes replaces the signed-in admin's recovery codes.
+func (s *Server) HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	if !user.TOTPEnabled {
+		http.Redirect(w, r, "/admin/account?error=Two-factor authentication is not enabled", http.StatusSeeOther)
+		return
+	}
+	if !s.checkPassword(w, r, user) {
+		return
+	}
+
+	log.Printf("Admin user %s regenerated recovery codes", user.Username)
+	s.issueRecoveryCodes(w, r, user, "New recovery codes generated")
+}
+
+func (s *Server) issueRecoveryCodes(w http.ResponseWriter, r *http.Request, user *models.AdminUser, success string) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err == nil {
+		err = s.db.ReplaceRecoveryCodes(user.ID, codes)
+	}
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		success += ", but recovery codes could not be generated. Try regenerating them."
+		codes = nil
+	}
+
+	data := struct {
+		PageData
+		RecoveryCodes []string
+	}{
+		PageData: PageData{
+			Title:      "Recovery Codes",
+			ShowNav:    true,
+			ActivePage: "account",
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			Success:    success,
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		RecoveryCodes: codes,
+	}
+
+	s.renderTemplate(w, "recovery-codes.html", data)
+}
+
+// HandleTwoFactorDisable turns off 2FA for the signed-in admin after
+// re-checking their password.
+func (s *Server) HandleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	if s.authService.TwoFactorRequired() {
+		http.Redirect(w, r, "/admin/account?error=Two-factor authentication is required for all admins", http.StatusSeeOther)
+		return
+	}
+	if !s.checkPassword(w, r, user) {
+		return
+	}
+
+	if err := s.db.DisableAdminUserTOTP(user.ID); err != nil {
+		log.Printf("Error disabling 2FA: %v", err)
+		http.Redirect(w, r, "/admin/account?error=Failed to disable two-factor authentication", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Admin user %s disabled two-factor authentication", user.Username)
+	http.Redirect(w, r, "/admin/account?success=Two-factor authentication disabled", http.StatusSeeOther)
+}
+
+// checkPassword verifies the password form value for sensitive account
+// changes. On failure it redirects back to the account page.
+func (s *Server) checkPassword(w http.ResponseWriter, r *http.Request, user *models.AdminUser) bool {
+	if msg := s.verifyPassword(r, user, r.FormValue("password")); msg != "" {
+		http.Redirect(w, r, "/admin/account?error="+msg, http.StatusSeeOther)
+		return false
+	}
+	return true
+}
+
+// verifyPassword checks the password of a signed-in admin, returning a
+// message for the user if it can't be accepted. Attempts are throttled and
+// recorded like sign-ins, so a stolen session can't be used to guess the
+// password.
+func (s *Server) verifyPassword(r *http.Request, user *models.AdminUser, password string) string {
+	if wait, err := s.authService.CheckLoginAllowed(r, user.Username); err != nil {
+		log.Printf("Error checking login attempts: %v", err)
+		return "Failed to verify password"
+	} else if wait > 0 {
+		return tooManyAttemptsMessage(wait)
+	}
+
+	verified, err := s.authService.LoginAdmin(user.Username, password)
+	if err != nil {
+		log.Printf("Error verifying password: %v", err)
+		return "Failed to verify password"
+	}
+	if verified == nil {
+		s.authService.RecordLoginAttempt(r, user.Username, models.LoginStageReauth, models.LoginResultFailure)
+		return "Password is incorrect"
+	}
+	s.authService.RecordLoginAttempt(r, user.Username, models.LoginStageReauth, models.LoginResultSuccess)
+	return ""
+}
+
+// HandleResetUserTwoFactor lets an owner remove 2FA from an admin who has
+// lost their device and recovery codes.
+func (s *Server) HandleResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := s.targetUser(w, r)
+	if target == nil {
+		return
+	}
+
+	if err := s.db.DisableAdminUserTOTP(target.ID); err != nil {
+		log.Printf("Error resetting 2FA: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to reset two-factor authentication", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Reset two-factor authentication for admin user %s", target.Username)
+	http.Redirect(w, r, "/admin/users?success=Two-factor authentication reset successfully", http.StatusSeeOther)
+}
@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"strconv"
+	"time"
+)
+
+// usageRange is a period the dashboard can chart usage over, ending with
+// the current hour or day.
+type usageRange struct {
+	Value    string
+	Label    string
+	Interval string
+	Buckets  int
+}
+
+// Ranges offered on the dashboard. Shorter ranges are charted by hour.
+var usageRanges = []usageRange{
+	{Value: "24h", Label: "24 hours", Interval: models.UsageIntervalHour, Buckets: 24},
+	{Value: "7d", Label: "7 days", Interval: models.UsageIntervalHour, Buckets: 7 * 24},
+	{Value: "30d", Label: "30 days", Interval: models.UsageIntervalDay, Buckets: 30},
+	{Value: "90d", Label: "90 days", Interval: models.UsageIntervalDay, Buckets: 90},
+	{Value: "365d", Label: "365 days", Interval: models.UsageIntervalDay, Buckets: 365},
+}
+
+const defaultUsageRange = "7d"
+
+// parseUsageRange returns the range named by value, or the default range.
+func parseUsageRange(value string) usageRange {
+	var fallback usageRange
+	for _, rng := range usageRanges {
+		if rng.Value == value {
+			return rng
+		}
+		if rng.Value == defaultUsageRange {
+			fallback = rng
+		}
+	}
+	return fallback
+}
+
+// step is the length of one bucket.
+func (rng usageRange) step() time.Duration {
+	if rng.Interval == models.UsageIntervalDay {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// bounds returns the start of the range's first bucket and the end of its
+// last, which holds now.
+func (rng usageRange) bounds(now time.Time) (time.Time, time.Time) {
+	step := rng.step()
+	end := now.UTC().Truncate(step).Add(step)
+	return end.Add(-time.Duration(rng.Buckets) * step), end
+}
+
+// bucketLayout formats the start of a bucket in charts and exports.
+func (rng usageRange) bucketLayout() string {
+	if rng.Interval == models.UsageIntervalDay {
+		return "2006-01-02"
+	}
+	return "2006-01-02 15:04"
+}
+
+// usageReport is the API usage over a range.
+type usageReport struct {
+	Range  usageRange
+	Total  *models.UsageBucket
+	Series []models.UsageBucket
+	Keys   []models.KeyUsage
+}
+
+// loadUsage reads the usage over rng. The series has a bucket for every
+// hour or day, including those without usage. Per-key usage is only loaded
+// if byKey is set.
+func (s *Server) loadUsage(rng usageRange, byKey bool) (*usageReport, error) {
+	from, to := rng.bounds(time.Now())
+	report := &usageReport{Range: rng}
+
+	total, err := s.db.GetUsageTotal(rng.Interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report.Total = total
+
+	series, err := s.db.GetUsageSeries(rng.Interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	report.Series = make([]models.UsageBucket, 0, rng.Buckets)
+	for start := from; start.Before(to); start = start.Add(rng.step()) {
+		bucket := models.UsageBucket{Start: start}
+		if len(series) > 0 && series[0].Start.Equal(start) {
+			bucket = series[0]
+			series = series[1:]
+		}
+		report.Series = append(report.Series, bucket)
+	}
+
+	if byKey {
+		if report.Keys, err = s.db.GetUsageByKey(rng.Interval, from, to); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// usageChart is the chart data embedded in the dashboard.
+type usageChart struct {
+	Labels       []string `json:"labels"`
+	Requests     []int    `json:"requests"`
+	ImagesServed []int    `json:"images_served"`
+	Errors       []int    `json:"errors"`
+	UniqueKeys   []int    `json:"unique_keys"`
+}
+
+func (report *usageReport) chart() usageChart {
+	chart := usageChart{}
+	for _, b := range report.Series {
+		chart.Labels = append(chart.Labels, b.Start.Format(report.Range.bucketLayout()))
+		chart.Requests = append(chart.Requests, b.Requests)
+		chart.ImagesServed = append(chart.ImagesServed, b.ImagesServed)
+		chart.Errors = append(chart.Errors, b.Errors)
+		chart.UniqueKeys = append(chart.UniqueKeys, b.UniqueKeys)
+	}
+	return chart
+}
+
+// keyUsageName is how a key is labelled in usage breakdowns.
+func keyUsageName(u models.KeyUsage) string {
+	switch {
+	case u.APIKeyID == 0:
+		return "No API key"
+	case u.Name == "":
+		return fmt.Sprintf("Deleted key #%d", u.APIKeyID)
+	default:
+		return u.Name
+	}
+}
+
+// HandleUsageExport downloads the usage over a dashboard range as CSV, with
+// a row per hour or day, or with breakdown=key a row per API key. Only
+// owners, who manage the keys, can break usage down by key.
+func (s *Server) HandleUsageExport(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAdminFromContext(r.Context())
+	rng := parseUsageRange(r.URL.Query().Get("range"))
+	byKey := r.URL.Query().Get("breakdown") == "key"
+	if byKey && !user.HasRole(models.RoleOwner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	report, err := s.loadUsage(rng, byKey)
+	if err != nil {
+		log.Printf("Error getting usage: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var rows [][]string
+	filename := "usage-" + rng.Value
+	if byKey {
+		filename += "-by-key"
+		rows = append(rows, []string{"api_key_id", "name", "requests", "images_served", "errors"})
+		for _, u := range report.Keys {
+			rows = append(rows, []string{strconv.Itoa(u.APIKeyID), keyUsageName(u),
+				strconv.Itoa(u.Requests), strconv.Itoa(u.ImagesServed), strconv.Itoa(u.Errors)})
+		}
+	} else {
+		rows = append(rows, []string{rng.Interval + " (UTC)", "requests", "images_served", "errors", "unique_keys"})
+		for _, b := range report.Series {
+			rows = append(rows, []string{b.Start.Format(rng.bucketLayout()),
+				strconv.Itoa(b.Requests), strconv.Itoa(b.ImagesServed), strconv.Itoa(b.Errors), strconv.Itoa(b.UniqueKeys)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	if err := csv.NewWriter(w).WriteAll(rows); err != nil {
+		log.Printf("Error writing usage export: %v", err)
+	}
+}
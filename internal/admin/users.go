@@ -0,0 +1,347 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"shufflr/internal/auth"
+	"shufflr/internal/models"
+	"strconv"
+)
+
+// roleDescriptions explains each admin role on the users page.
+var roleDescriptions = map[string]string{
+	models.RoleOwner:  "Full access, including API keys, settings and users",
+	models.RoleEditor: "Upload, rename, enable and delete images",
+	models.RoleViewer: "Read-only access to the dashboard and image library",
+}
+
+// RoleOption describes an admin role choice in the user forms.
+type RoleOption struct {
+	Name        string
+	Description string
+}
+
+func roleOptions() []RoleOption {
+	options := make([]RoleOption, 0, len(models.AllRoles))
+	for _, role := range models.AllRoles {
+		options = append(options, RoleOption{Name: role, Description: roleDescriptions[role]})
+	}
+	return options
+}
+
+// validateCredentials checks a new username and password, returning an
+// error message for the first problem found.
+func validateCredentials(username, password, confirmPassword string) string {
+	if username == "" || password == "" {
+		return "Username and password are required"
+	}
+	if len(username) < 3 {
+		return "Username must be at least 3 characters"
+	}
+	return validatePassword(password, confirmPassword)
+}
+
+func validatePassword(password, confirmPassword string) string {
+	if len(password) < 6 {
+		return "Password must be at least 6 characters"
+	}
+	if password != confirmPassword {
+		return "Passwords do not match"
+	}
+	return ""
+}
+
+// User management
+func (s *Server) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAdminFromContext(r.Context())
+
+	users, err := s.db.GetAllAdminUsers()
+	if err != nil {
+		log.Printf("Error getting admin users: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		PageData
+		Users         []*models.AdminUser
+		Roles         []RoleOption
+		CurrentUserID int
+	}{
+		PageData: PageData{
+			Title:      "Users",
+			ShowNav:    true,
+			ActivePage: "users",
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			Success:    r.URL.Query().Get("success"),
+			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		Users:         users,
+		Roles:         roleOptions(),
+		CurrentUserID: user.ID,
+	}
+
+	s.renderTemplate(w, "users.html", data)
+}
+
+func (s *Server) HandleNewUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+
+	if msg := validateCredentials(username, password, r.FormValue("confirm_password")); msg != "" {
+		http.Redirect(w, r, "/admin/users?error="+msg, http.StatusSeeOther)
+		return
+	}
+	if !models.IsValidRole(role) {
+		http.Redirect(w, r, "/admin/users?error=Invalid role", http.StatusSeeOther)
+		return
+	}
+
+	existing, err := s.db.GetAdminUserByUsername(username)
+	if err != nil {
+		log.Printf("Error checking admin user: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to create user", http.StatusSeeOther)
+		return
+	}
+	if existing != nil {
+		http.Redirect(w, r, "/admin/users?error=Username is already taken", http.StatusSeeOther)
+		return
+	}
+
+	if _, err := s.db.CreateAdminUser(username, password, role); err != nil {
+		log.Printf("Error creating admin user: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to create user", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Created admin user %s with role %s", username, role)
+	http.Redirect(w, r, "/admin/users?success=User created successfully", http.StatusSeeOther)
+}
+
+// targetUser parses the user_id form value, rejecting unknown users and the
+// signed-in admin's own account. On failure it redirects and returns nil.
+func (s *Server) targetUser(w http.ResponseWriter, r *http.Request) *models.AdminUser {
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		http.Redirect(w, r, "/admin/users?error=Invalid user ID", http.StatusSeeOther)
+		return nil
+	}
+
+	// Owners can't lock themselves out; this also guarantees one owner remains
+	if current := auth.GetAdminFromContext(r.Context()); current != nil && current.ID == userID {
+		http.Redirect(w, r, "/admin/users?error=You cannot change your own account here", http.StatusSeeOther)
+		return nil
+	}
+
+	target, err := s.db.GetAdminUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting admin user: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to load user", http.StatusSeeOther)
+		return nil
+	}
+	if target == nil {
+		http.Redirect(w, r, "/admin/users?error=User not found", http.StatusSeeOther)
+		return nil
+	}
+
+	return target
+}
+
+func (s *Server) HandleUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := s.targetUser(w, r)
+	if target == nil {
+		return
+	}
+
+	role := r.FormValue("role")
+	if !models.IsValidRole(role) {
+		http.Redirect(w, r, "/admin/users?error=Invalid role", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateAdminUserRole(target.ID, role); err != nil {
+		log.Printf("Error updating admin user role: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to update role", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Changed role of admin user %s to %s", target.Username, role)
+	http.Redirect(w, r, "/admin/users?success=Role updated successfully", http.StatusSeeOther)
+}
+
+func (s *Server) HandleToggleUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := s.targetUser(w, r)
+	if target == nil {
+		return
+	}
+
+	enabled := r.FormValue("enabled") == "true"
+	if err := s.db.UpdateAdminUserEnabled(target.ID, enabled); err != nil {
+		log.Printf("Error updating admin user: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to update user", http.StatusSeeOther)
+		return
+	}
+
+	status := "disabled"
+	if enabled {
+		status = "enabled"
+	}
+
+	log.Printf("Admin user %s %s", target.Username, status)
+	http.Redirect(w, r, "/admin/users?success=User "+status+" successfully", http.StatusSeeOther)
+}
+
+func (s *Server) HandleResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := s.targetUser(w, r)
+	if target == nil {
+		return
+	}
+
+	if target.IsSSO() {
+		http.Redirect(w, r, "/admin/users?error=Single sign-on users have no password to reset", http.StatusSeeOther)
+		return
+	}
+
+	if msg := validatePassword(r.FormValue("password"), r.FormValue("confirm_password")); msg != "" {
+		http.Redirect(w, r, "/admin/users?error="+msg, http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateAdminUserPassword(target.ID, r.FormValue("password")); err != nil {
+		log.Printf("Error resetting admin user password: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to reset password", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Reset password for admin user %s", target.Username)
+	http.Redirect(w, r, "/admin/users?success=Password reset successfully", http.StatusSeeOther)
+}
+
+func (s *Server) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := s.targetUser(w, r)
+	if target == nil {
+		return
+	}
+
+	if err := s.db.DeleteAdminUser(target.ID); err != nil {
+		log.Printf("Error deleting admin user: %v", err)
+		http.Redirect(w, r, "/admin/users?error=Failed to delete user", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Deleted admin user %s", target.Username)
+	http.Redirect(w, r, "/admin/users?success=User deleted successfully", http.StatusSeeOther)
+}
+
+// Account management for the signed-in admin
+func (s *Server) HandleAccount(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAdminFromContext(r.Context())
+
+	recoveryCodes := 0
+	if user.TOTPEnabled {
+		count, err := s.db.CountUnusedRecoveryCodes(user.ID)
+		if err != nil {
+			log.Printf("Error counting recovery codes: %v", err)
+		}
+		recoveryCodes = count
+	}
+
+	data := struct {
+		PageData
+		TwoFactorEnabled  bool
+		TwoFactorRequired bool
+		RecoveryCodesLeft int
+		SSO               bool
+	}{
+		PageData: PageData{
+			Title:      "Account",
+			ShowNav:    true,
+			ActivePage: "account",
+			Username:   user.Username,
+			Role:       user.Role,
+			BaseURL:    s.baseURL,
+			Success:    r.URL.Query().Get("success"),
+			Error:      r.URL.Query().Get("error"),
+			CSRFToken:  s.authService.CSRFToken(w, r),
+		},
+		TwoFactorEnabled:  user.TOTPEnabled,
+		TwoFactorRequired: s.authService.TwoFactorRequired(),
+		RecoveryCodesLeft: recoveryCodes,
+		SSO:               user.IsSSO(),
+	}
+
+	s.renderTemplate(w, "account.html", data)
+}
+
+func (s *Server) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := auth.GetAdminFromContext(r.Context())
+	if user.IsSSO() {
+		http.Redirect(w, r, "/admin/account?error=Your password is managed by your identity provider", http.StatusSeeOther)
+		return
+	}
+
+	if msg := s.verifyPassword(r, user, r.FormValue("current_password")); msg != "" {
+		http.Redirect(w, r, "/admin/account?error="+msg, http.StatusSeeOther)
+		return
+	}
+
+	password := r.FormValue("password")
+	if msg := validatePassword(password, r.FormValue("confirm_password")); msg != "" {
+		http.Redirect(w, r, "/admin/account?error="+msg, http.StatusSeeOther)
+		return
+	}
+
+	if err := s.db.UpdateAdminUserPassword(user.ID, password); err != nil {
+		log.Printf("Error changing password: %v", err)
+		http.Redirect(w, r, "/admin/account?error=Failed to change password", http.StatusSeeOther)
+		return
+	}
+
+	// The password change invalidated every session, so sign this one in again
+	updated, err := s.db.GetAdminUserByID(user.ID)
+	if err == nil && updated != nil {
+		err = s.authService.SetAdminSession(w, r, updated, models.AuthMethodPassword)
+	}
+	if err != nil {
+		log.Printf("Error refreshing session: %v", err)
+		http.Redirect(w, r, "/admin/login?success=Password changed, please sign in again", http.StatusSeeOther)
+		return
+	}
+
+	log.Printf("Admin user %s changed their password", user.Username)
+	http.Redirect(w, r, "/admin/account?success=Password changed successfully", http.StatusSeeOther)
+}
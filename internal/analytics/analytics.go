@@ -0,0 +1,152 @@
+package analytics
+
+import (
+	"context"
+	"log"
+	"shufflr/internal/models"
+	"shufflr/internal/storage"
+	"sync"
+	"time"
+)
+
+// FlushInterval is how often the recorder writes its counts by default.
+const FlushInterval = 30 * time.Second
+
+// Recorder counts how often images are served and fetched, and the API
+// usage, in memory, and writes the counts to the database in batches so
+// serving a request doesn't wait on a write.
+type Recorder struct {
+	db      *storage.DB
+	mu      sync.Mutex
+	pending map[int]*models.ImageServeCounts
+	usage   map[models.UsageKey]*models.UsageCounts
+}
+
+func NewRecorder(db *storage.DB) *Recorder {
+	return &Recorder{
+		db:      db,
+		pending: make(map[int]*models.ImageServeCounts),
+		usage:   make(map[models.UsageKey]*models.UsageCounts),
+	}
+}
+
+// Served records that the random images API picked the images, for the
+// request with ctx.
+func (r *Recorder) Served(ctx context.Context, images []*models.ImageFile) {
+	AddImagesServed(ctx, len(images))
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, img := range images {
+		counts := r.counts(img.ID)
+		counts.ServedCount++
+		counts.LastServedAt = &now
+	}
+}
+
+// Fetched records that an image's file was requested through the API.
+func (r *Recorder) Fetched(img *models.ImageFile) {
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := r.counts(img.ID)
+	counts.FetchedCount++
+	counts.LastFetchedAt = &now
+}
+
+// counts returns the pending counts of an image. The caller holds r.mu.
+func (r *Recorder) counts(imageID int) *models.ImageServeCounts {
+	counts, ok := r.pending[imageID]
+	if !ok {
+		counts = &models.ImageServeCounts{}
+		r.pending[imageID] = counts
+	}
+	return counts
+}
+
+// usageCounts returns the pending usage of an hour and key. The caller
+// holds r.mu.
+func (r *Recorder) usageCounts(key models.UsageKey) *models.UsageCounts {
+	counts, ok := r.usage[key]
+	if !ok {
+		counts = &models.UsageCounts{}
+		r.usage[key] = counts
+	}
+	return counts
+}
+
+// Flush writes the pending counts. If a write fails its counts are kept and
+// retried on the next flush.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	pending, usage := r.pending, r.usage
+	r.pending = make(map[int]*models.ImageServeCounts)
+	r.usage = make(map[models.UsageKey]*models.UsageCounts)
+	r.mu.Unlock()
+
+	var firstErr error
+	if len(pending) > 0 {
+		counts := make(map[int]models.ImageServeCounts, len(pending))
+		for imageID, c := range pending {
+			counts[imageID] = *c
+		}
+		if err := r.db.AddImageServeCounts(counts); err != nil {
+			r.restore(pending)
+			firstErr = err
+		}
+	}
+
+	if len(usage) > 0 {
+		counts := make(map[models.UsageKey]models.UsageCounts, len(usage))
+		for key, c := range usage {
+			counts[key] = *c
+		}
+		if err := r.db.AddUsage(counts); err != nil {
+			r.restoreUsage(usage)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// restore adds counts that failed to be written back to the pending ones.
+func (r *Recorder) restore(failed map[int]*models.ImageServeCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for imageID, c := range failed {
+		counts := r.counts(imageID)
+		counts.ServedCount += c.ServedCount
+		counts.FetchedCount += c.FetchedCount
+		if counts.LastServedAt == nil {
+			counts.LastServedAt = c.LastServedAt
+		}
+		if counts.LastFetchedAt == nil {
+			counts.LastFetchedAt = c.LastFetchedAt
+		}
+	}
+}
+
+// restoreUsage adds usage that failed to be written back to the pending
+// usage.
+func (r *Recorder) restoreUsage(failed map[models.UsageKey]*models.UsageCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, c := range failed {
+		counts := r.usageCounts(key)
+		counts.Requests += c.Requests
+		counts.ImagesServed += c.ImagesServed
+		counts.Errors += c.Errors
+	}
+}
+
+// Run flushes the pending counts every interval. It never returns.
+func (r *Recorder) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := r.Flush(); err != nil {
+			log.Printf("Error saving usage statistics: %v", err)
+		}
+	}
+}
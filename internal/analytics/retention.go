@@ -0,0 +1,41 @@
+package analytics
+
+import (
+	"shufflr/internal/storage"
+	"strconv"
+	"time"
+)
+
+const defaultRequestLogRetentionDays = 90
+
+// MinRequestLogRetentionDays keeps a whole month of API requests in the log,
+// as monthly image quotas are counted from it.
+const MinRequestLogRetentionDays = 31
+
+// RequestLogRetentionDays returns how long entries are kept in the API
+// request log. Shorter stored periods are raised to the minimum.
+func RequestLogRetentionDays(db *storage.DB) int {
+	value, err := db.GetSetting("request_log_retention_days")
+	if err != nil {
+		return defaultRequestLogRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultRequestLogRetentionDays
+	}
+	if days < MinRequestLogRetentionDays {
+		return MinRequestLogRetentionDays
+	}
+	return days
+}
+
+// CompactRequestLog deletes API request log entries older than the retention
+// period, returning how many were deleted. It only prunes the log: the usage
+// statistics are counted live by the Recorder, which keeps counts that fail
+// to be written and retries them, rather than rolled up from the log. The
+// log only holds successful random image requests made with a key, so
+// rolling it up as well would count those requests twice.
+func CompactRequestLog(db *storage.DB) (int64, error) {
+	days := RequestLogRetentionDays(db)
+	return db.DeleteAPIRequestsBefore(time.Now().AddDate(0, 0, -days))
+}
@@ -0,0 +1,38 @@
+package analytics
+
+import (
+	"path/filepath"
+	"shufflr/internal/storage"
+	"testing"
+)
+
+func TestRequestLogRetentionDays(t *testing.T) {
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"90", 90},
+		{"365", 365},
+		{"31", 31},
+		// Shorter periods would lose part of the monthly quota window
+		{"30", MinRequestLogRetentionDays},
+		{"0", MinRequestLogRetentionDays},
+		{"-5", MinRequestLogRetentionDays},
+		{"", defaultRequestLogRetentionDays},
+		{"forever", defaultRequestLogRetentionDays},
+	}
+	for _, tt := range tests {
+		if err := db.SetSetting("request_log_retention_days", tt.value); err != nil {
+			t.Fatal(err)
+		}
+		if got := RequestLogRetentionDays(db); got != tt.want {
+			t.Errorf("RequestLogRetentionDays with %q = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package analytics
+
+import (
+	"context"
+	"net/http"
+	"shufflr/internal/models"
+	"time"
+)
+
+type contextKey int
+
+const usageKey contextKey = 0
+
+// requestUsage is filled in while a request is handled, as the API key and
+// number of images are only known further down the handler chain.
+type requestUsage struct {
+	apiKeyID int
+	images   int
+}
+
+// Middleware counts the API requests it handles towards the usage
+// statistics.
+func (r *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// CORS preflights are made by browsers on top of the actual request
+		if req.Method == http.MethodOptions {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		usage := &requestUsage{}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), usageKey, usage)))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		counts := r.usageCounts(models.UsageKey{Hour: time.Now().UTC().Truncate(time.Hour), APIKeyID: usage.apiKeyID})
+		counts.Requests++
+		counts.ImagesServed += usage.images
+		if sw.status >= 400 {
+			counts.Errors++
+		}
+	})
+}
+
+// SetAPIKey counts the request towards an API key's usage.
+func SetAPIKey(ctx context.Context, apiKeyID int) {
+	if usage, ok := ctx.Value(usageKey).(*requestUsage); ok {
+		usage.apiKeyID = apiKeyID
+	}
+}
+
+// AddImagesServed counts images returned by the request.
+func AddImagesServed(ctx context.Context, n int) {
+	if usage, ok := ctx.Value(usageKey).(*requestUsage); ok {
+		usage.images += n
+	}
+}
+
+// statusWriter remembers the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -7,24 +7,40 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"shufflr/internal/analytics"
 	"shufflr/internal/auth"
+	"shufflr/internal/metrics"
 	"shufflr/internal/models"
+	"shufflr/internal/signing"
 	"shufflr/internal/storage"
+	"shufflr/internal/uploads"
 	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	imagesServed = metrics.NewCounter("shufflr_images_served_total",
+		"Images returned by the random images API.")
+	imageBytesServed = metrics.NewCounter("shufflr_image_bytes_served_total",
+		"Bytes of image files sent by the API.")
 )
 
 type Server struct {
 	db          *storage.DB
 	authService *auth.AuthService
+	signer      *signing.Signer
 	uploadDir   string
+	recorder    *analytics.Recorder
 }
 
-func NewServer(db *storage.DB, authService *auth.AuthService, uploadDir string) *Server {
+func NewServer(db *storage.DB, authService *auth.AuthService, signer *signing.Signer, uploadDir string, recorder *analytics.Recorder) *Server {
 	return &Server{
 		db:          db,
 		authService: authService,
+		signer:      signer,
 		uploadDir:   uploadDir,
+		recorder:    recorder,
 	}
 }
 
@@ -34,11 +50,43 @@ type RandomImagesResponse struct {
 }
 
 type ImageResponse struct {
-	URL      string `json:"url"`
+	URL       string     `json:"url"`
+	Filename  string     `json:"filename"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	models.ImageMetadata
+}
+
+type UploadImagesResponse struct {
+	Uploaded []ImageResponse `json:"uploaded"`
+	Errors   []UploadError   `json:"errors"`
+}
+
+type UploadError struct {
 	Filename string `json:"filename"`
+	Error    string `json:"error"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Key           string `json:"key"`
+	SigningSecret string `json:"signing_secret"`
+}
+
+type StatsResponse struct {
+	EnabledImageCount int `json:"enabled_image_count"`
+	TotalImageCount   int `json:"total_image_count"`
+	APIKeyCount       int `json:"api_key_count"`
+	ActiveAPIKeyCount int `json:"active_api_key_count"`
+	RequestCount      int `json:"request_count"`
 }
 
-func (s *Server) setCORSHeaders(w http.ResponseWriter) {
+func (s *Server) setCORSHeaders(w http.ResponseWriter, r *http.Request, key *models.APIKey) {
 	// Get CORS settings
 	corsEnabled, err := s.db.GetSetting("cors_enabled")
 	if err != nil {
@@ -50,6 +98,16 @@ func (s *Server) setCORSHeaders(w http.ResponseWriter) {
 		if err != nil || corsOrigins == "" {
 			corsOrigins = "*"
 		}
+
+		// Keys restricted to specific origins only ever allow the matching request origin
+		if key != nil && len(key.AllowedOrigins) > 0 {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !auth.MatchOrigin(key.AllowedOrigins, origin) {
+				return
+			}
+			corsOrigins = origin
+			w.Header().Add("Vary", "Origin")
+		}
 		
 		w.Header().Set("Access-Control-Allow-Origin", corsOrigins)
 		w.Header().Set("Access-Control-Allow-Methods", "GET")
@@ -112,8 +170,19 @@ func (s *Server) HandleRandomImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Keys restricted to a set of images only draw from those
+	var allowedImageIDs []int
+	if apiKey != nil {
+		allowedImageIDs = apiKey.AllowedImageIDs
+	}
+
 	// Check if requested count exceeds total images
-	totalImages, err := s.db.GetImageFileCount()
+	var totalImages int
+	if len(allowedImageIDs) > 0 {
+		totalImages, err = s.db.GetImageFileCountIn(allowedImageIDs)
+	} else {
+		totalImages, err = s.db.GetImageFileCount()
+	}
 	if err != nil {
 		log.Printf("Error getting image count: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -125,13 +194,29 @@ func (s *Server) HandleRandomImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the key's remaining image quota
+	if apiKey != nil {
+		quota, err := s.authService.GetImageQuota(apiKey)
+		if err != nil {
+			log.Printf("Error getting image quota: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if quota.Limited && count > quota.Remaining {
+			auth.RejectQuotaExceeded(w, quota, fmt.Sprintf("Requested count (%d) exceeds remaining image quota (%d)", count, quota.Remaining))
+			return
+		}
+	}
+
 	// Get random images
-	images, err := s.db.GetRandomImageFiles(count)
+	images, err := s.db.GetRandomImageFiles(count, allowedImageIDs)
 	if err != nil {
 		log.Printf("Error getting random images: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	s.recorder.Served(r.Context(), images)
+	imagesServed.Add(float64(len(images)))
 
 	// Build response
 	response := RandomImagesResponse{
@@ -139,10 +224,23 @@ func (s *Server) HandleRandomImages(w http.ResponseWriter, r *http.Request) {
 		Count:  len(images),
 	}
 
+	// Sign image URLs when enabled in settings or requested by the client
+	signURLs := r.URL.Query().Get("signed") == "true"
+	if val, err := s.db.GetSetting("sign_image_urls"); err == nil && val == "true" {
+		signURLs = true
+	}
+	ttl := s.signedURLTTL()
+
 	for i, img := range images {
 		response.Images[i] = ImageResponse{
-			URL:      fmt.Sprintf("/api/images/%s", img.Filename),
-			Filename: img.Filename,
+			URL:           fmt.Sprintf("/api/images/%s", img.Filename),
+			Filename:      img.Filename,
+			ImageMetadata: img.ImageMetadata,
+		}
+		if signURLs {
+			expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+			response.Images[i].URL = s.signer.SignImageURL(img.Filename, ttl)
+			response.Images[i].ExpiresAt = &expiresAt
 		}
 	}
 
@@ -155,7 +253,7 @@ func (s *Server) HandleRandomImages(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
-	s.setCORSHeaders(w)
+	s.setCORSHeaders(w, r, apiKey)
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Error encoding response: %v", err)
@@ -177,32 +275,24 @@ func (s *Server) HandleServeImage(w http.ResponseWriter, r *http.Request) {
 		requireAPIKey = "true" // Default to secure
 	}
 
-	if requireAPIKey == "true" {
-		// Check for API key in headers when required
-		apiKeyHeader := r.Header.Get("X-API-Key")
-		if apiKeyHeader == "" {
-			apiKeyHeader = r.Header.Get("Authorization")
-			if strings.HasPrefix(apiKeyHeader, "Bearer ") {
-				apiKeyHeader = strings.TrimPrefix(apiKeyHeader, "Bearer ")
-			}
-		}
-		
-		if apiKeyHeader == "" {
-			http.Error(w, "API key required", http.StatusUnauthorized)
+	// A valid signed URL grants access without an API key
+	var signedUntil time.Time
+	if sig := r.URL.Query().Get("sig"); sig != "" {
+		signedFilename := filepath.Base(strings.TrimPrefix(r.URL.Path, "/api/images/"))
+		expiresAt, ok := s.signer.Verify(signedFilename, r.URL.Query().Get("exp"), sig)
+		if !ok {
+			http.Error(w, "Invalid or expired signature", http.StatusForbidden)
 			return
 		}
+		signedUntil = expiresAt
+	}
 
-		// Validate API key
-		apiKey, err := s.db.GetAPIKeyByKey(apiKeyHeader)
-		if err != nil || apiKey == nil {
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+	var apiKey *models.APIKey
+	if requireAPIKey == "true" && signedUntil.IsZero() {
+		apiKey = s.authService.AuthenticateAPIKey(w, r, models.ScopeImagesRead)
+		if apiKey == nil {
 			return
 		}
-
-		// Update last used timestamp
-		if err := s.db.UpdateAPIKeyLastUsed(apiKey.ID); err != nil {
-			log.Printf("Error updating API key last used: %v", err)
-		}
 	}
 
 	// Extract filename from URL path
@@ -221,30 +311,19 @@ func (s *Server) HandleServeImage(w http.ResponseWriter, r *http.Request) {
 	// Security: prevent directory traversal
 	filename = filepath.Base(filename)
 	
-	// Check if image exists in database and is enabled
-	images, err := s.db.GetAllImageFiles()
+	// Check if image exists in database, is enabled and is inside its publishing window
+	img, err := s.db.GetImageFileByFilename(filename)
 	if err != nil {
-		log.Printf("Error getting image files: %v", err)
+		log.Printf("Error getting image file: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	var foundImage bool
-	var mimeType string
-	var isEnabled bool
-	for _, img := range images {
-		if img.Filename == filename {
-			foundImage = true
-			mimeType = img.MimeType
-			isEnabled = img.Enabled
-			break
-		}
-	}
-
-	if !foundImage || !isEnabled {
+	if img == nil || !img.IsServed(time.Now()) || (apiKey != nil && !apiKey.CanAccessImage(img.ID)) {
 		http.Error(w, "Image not found", http.StatusNotFound)
 		return
 	}
+	mimeType := img.MimeType
 
 	// Serve the file
 	filePath := filepath.Join(s.uploadDir, filename)
@@ -257,11 +336,292 @@ func (s *Server) HandleServeImage(w http.ResponseWriter, r *http.Request) {
 
 	// Set appropriate headers
 	w.Header().Set("Content-Type", mimeType)
-	w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
-	s.setCORSHeaders(w)
+	// Don't let caches serve a signed URL past its expiry, or an image past
+	// the end of its publishing window
+	cacheUntil := signedUntil
+	if img.UnpublishAt != nil && (cacheUntil.IsZero() || img.UnpublishAt.Before(cacheUntil)) {
+		cacheUntil = *img.UnpublishAt
+	}
+	if !cacheUntil.IsZero() {
+		maxAge := int(time.Until(cacheUntil).Seconds())
+		if maxAge > 86400 {
+			maxAge = 86400
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
+	}
+	s.setCORSHeaders(w, r, apiKey)
+	s.recorder.Fetched(img)
 
 	// Serve the file
-	http.ServeFile(w, r, filePath)
+	http.ServeFile(metrics.ByteCounter(w, imageBytesServed), r, filePath)
+}
+
+// HandleUploadImages accepts a multipart upload of one or more files in the "images" field.
+func (s *Server) HandleUploadImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB max
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		http.Error(w, "No files provided", http.StatusBadRequest)
+		return
+	}
+
+	response := UploadImagesResponse{
+		Uploaded: []ImageResponse{},
+		Errors:   []UploadError{},
+	}
+
+	for _, fileHeader := range files {
+		img, err := uploads.Save(s.db, s.uploadDir, fileHeader)
+		if err != nil {
+			response.Errors = append(response.Errors, UploadError{
+				Filename: fileHeader.Filename,
+				Error:    err.Error(),
+			})
+			continue
+		}
+		response.Uploaded = append(response.Uploaded, ImageResponse{
+			URL:      fmt.Sprintf("/api/images/%s", img.Filename),
+			Filename: img.Filename,
+		})
+	}
+
+	status := http.StatusCreated
+	if len(response.Uploaded) == 0 {
+		status = http.StatusBadRequest
+	}
+
+	s.writeJSON(w, r, status, response)
+}
+
+// HandleDeleteImage moves an image to the trash.
+func (s *Server) HandleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := filepath.Base(strings.TrimPrefix(r.URL.Path, "/api/images/"))
+	if filename == "" || filename == "." || filename == "/" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	trashed, err := uploads.Trash(s.db, s.uploadDir, filename)
+	if err != nil {
+		log.Printf("Error moving image to trash: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !trashed {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAPIKeys lists API keys (GET) or creates a new one (POST).
+func (s *Server) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.db.GetAllAPIKeys()
+		if err != nil {
+			log.Printf("Error getting API keys: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if keys == nil {
+			keys = []*models.APIKey{}
+		}
+		s.writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"keys":  keys,
+			"count": len(keys),
+		})
+
+	case http.MethodPost:
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 100 {
+			http.Error(w, "Name is required and must be 100 characters or less", http.StatusBadRequest)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{models.ScopeImagesRead}
+		}
+
+		// A key may only grant scopes it holds itself
+		caller := auth.GetAPIKeyFromContext(r.Context())
+		for _, scope := range req.Scopes {
+			if !models.IsValidScope(scope) {
+				http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+				return
+			}
+			if caller != nil && !caller.HasScope(scope) {
+				http.Error(w, "Cannot grant scope not held by this key: "+scope, http.StatusForbidden)
+				return
+			}
+		}
+
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		newKey := &models.APIKey{Name: req.Name, Scopes: req.Scopes, ExpiresAt: req.ExpiresAt}
+		if caller != nil {
+			// Keys created with a key inherit its restrictions and limits,
+			// or a restricted key could mint an unrestricted one
+			newKey.AllowedOrigins = caller.AllowedOrigins
+			newKey.AllowedReferrers = caller.AllowedReferrers
+			newKey.AllowedIPs = caller.AllowedIPs
+			newKey.AllowedImageIDs = caller.AllowedImageIDs
+			newKey.RateLimitPerSecond = caller.RateLimitPerSecond
+			newKey.RateLimitBurst = caller.RateLimitBurst
+			newKey.DailyImageQuota = caller.DailyImageQuota
+			newKey.MonthlyImageQuota = caller.MonthlyImageQuota
+		}
+
+		key, rawKey, err := s.db.CreateRestrictedAPIKey(newKey)
+		if err != nil {
+			log.Printf("Error creating API key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Created API key via API: %s (ID: %d)", key.Name, key.ID)
+		s.writeJSON(w, r, http.StatusCreated, CreateAPIKeyResponse{
+			APIKey:        key,
+			Key:           rawKey,
+			SigningSecret: s.authService.SigningSecret(rawKey),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAPIKey deletes a single API key addressed as /api/keys/{id}.
+func (s *Server) HandleAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/keys/"))
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return
+	}
+
+	key, err := s.db.GetAPIKeyByID(keyID)
+	if err != nil {
+		log.Printf("Error getting API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.DeleteAPIKey(keyID); err != nil {
+		log.Printf("Error deleting API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted API key via API: %d", keyID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleStats reports library and usage counters.
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	enabledImageCount, err := s.db.GetImageFileCount()
+	if err != nil {
+		log.Printf("Error getting image count: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	totalImageCount, err := s.db.GetTotalImageFileCount()
+	if err != nil {
+		log.Printf("Error getting total image count: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := s.db.GetAllAPIKeys()
+	if err != nil {
+		log.Printf("Error getting API keys: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	activeKeyCount := 0
+	for _, key := range keys {
+		if key.Enabled {
+			activeKeyCount++
+		}
+	}
+
+	usage, err := s.db.GetAllTimeUsage()
+	if err != nil {
+		log.Printf("Error getting request count: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, StatsResponse{
+		EnabledImageCount: enabledImageCount,
+		TotalImageCount:   totalImageCount,
+		APIKeyCount:       len(keys),
+		ActiveAPIKeyCount: activeKeyCount,
+		RequestCount:      usage.Requests,
+	})
+}
+
+// signedURLTTL returns how long signed image URLs stay valid.
+func (s *Server) signedURLTTL() time.Duration {
+	ttlStr, err := s.db.GetSetting("signed_url_ttl_seconds")
+	if err != nil || ttlStr == "" {
+		ttlStr = "3600"
+	}
+	ttl, err := strconv.Atoi(ttlStr)
+	if err != nil || ttl < 1 {
+		ttl = 3600
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	s.setCORSHeaders(w, r, auth.GetAPIKeyFromContext(r.Context()))
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
 }
 
 func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
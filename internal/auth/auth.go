@@ -3,11 +3,15 @@ package auth
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
+	"shufflr/internal/analytics"
 	"shufflr/internal/models"
+	"shufflr/internal/ratelimit"
 	"shufflr/internal/storage"
 	"strings"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
@@ -15,17 +19,27 @@ import (
 
 type contextKey string
 
+// ErrUserDisabled is returned when an external identity maps to a disabled
+// admin account.
+var ErrUserDisabled = errors.New("admin user is disabled")
+
 const (
 	sessionName        = "shufflr-session"
 	adminUserKey       = contextKey("admin_user")
 	apiKeyKey          = contextKey("api_key")
-	sessionUserIDKey   = "user_id"
-	sessionUsernameKey = "username"
+	adminSessionKey    = contextKey("admin_session")
+	sessionTokenKey    = "session_token"
 )
 
 type AuthService struct {
-	db    *storage.DB
-	store sessions.Store
+	db      *storage.DB
+	store   *secureCookieStore
+	limiter *ratelimit.Limiter
+	nonces  *nonceCache
+	oidc    *OIDCOptions
+	proxy   *ProxyAuthOptions
+
+	requestSigningSecret []byte
 }
 
 func NewAuthService(db *storage.DB, sessionSecret string) *AuthService {
@@ -60,13 +74,14 @@ func NewAuthService(db *storage.DB, sessionSecret string) *AuthService {
 		Path:     "/",
 		MaxAge:   24 * 60 * 60, // 24 hours
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
 		SameSite: http.SameSiteLaxMode,
 	}
 
 	return &AuthService{
-		db:    db,
-		store: store,
+		db:      db,
+		store:   &secureCookieStore{CookieStore: store},
+		limiter: ratelimit.New(),
+		nonces:  newNonceCache(),
 	}
 }
 
@@ -76,8 +91,11 @@ func (a *AuthService) LoginAdmin(username, password string) (*models.AdminUser,
 	if err != nil {
 		return nil, err
 	}
-	if user == nil {
-		return nil, nil // User not found
+	if user == nil || !user.Enabled {
+		return nil, nil // User not found or disabled
+	}
+	if user.IsSSO() {
+		return nil, nil // Signs in through the OpenID provider only
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
@@ -88,50 +106,113 @@ func (a *AuthService) LoginAdmin(username, password string) (*models.AdminUser,
 	return user, nil
 }
 
-func (a *AuthService) SetAdminSession(w http.ResponseWriter, r *http.Request, user *models.AdminUser) error {
+// SetAdminSession signs user in by starting a new server-side session and
+// storing its token in the session cookie. Any session the cookie already
+// referred to is ended. authMethod records how the user proved their identity.
+func (a *AuthService) SetAdminSession(w http.ResponseWriter, r *http.Request, user *models.AdminUser, authMethod string) error {
+	_, err := a.startSession(w, r, user, authMethod)
+	return err
+}
+
+func (a *AuthService) startSession(w http.ResponseWriter, r *http.Request, user *models.AdminUser, authMethod string) (*models.AdminSession, error) {
 	session, err := a.store.Get(r, sessionName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	session.Values[sessionUserIDKey] = user.ID
-	session.Values[sessionUsernameKey] = user.Username
+	if existing := a.sessionFromCookie(session); existing != nil {
+		if err := a.db.DeleteAdminSession(existing.ID); err != nil {
+			return nil, err
+		}
+	}
 
-	return session.Save(r, w)
+	timeouts := a.SessionTimeouts()
+	a.pruneSessions(timeouts)
+
+	adminSession, token, err := a.db.CreateAdminSession(user.ID, authMethod, r.UserAgent(), a.clientIPString(r))
+	if err != nil {
+		return nil, err
+	}
+	adminSession.Username = user.Username
+
+	session.Values[sessionTokenKey] = token
+	// Issue a fresh CSRF token for the signed-in session
+	delete(session.Values, csrfTokenKey)
+	delete(session.Values, pendingUserIDKey)
+	delete(session.Values, pendingVersionKey)
+	delete(session.Values, pendingExpiresKey)
+	session.Options.MaxAge = int(timeouts.Lifetime.Seconds())
+
+	if err := session.Save(r, w); err != nil {
+		return nil, err
+	}
+	return adminSession, nil
 }
 
+// ClearAdminSession signs out the current session.
 func (a *AuthService) ClearAdminSession(w http.ResponseWriter, r *http.Request) error {
 	session, err := a.store.Get(r, sessionName)
 	if err != nil {
 		return err
 	}
 
+	if existing := a.sessionFromCookie(session); existing != nil {
+		if err := a.db.DeleteAdminSession(existing.ID); err != nil {
+			return err
+		}
+	}
+
 	session.Values = make(map[interface{}]interface{})
 	session.Options.MaxAge = -1
 
 	return session.Save(r, w)
 }
 
+// GetAdminFromSession returns the signed-in admin, or nil if the request has
+// no valid session.
 func (a *AuthService) GetAdminFromSession(r *http.Request) (*models.AdminUser, error) {
-	session, err := a.store.Get(r, sessionName)
+	user, _, err := a.loadSession(r)
+	return user, err
+}
+
+func (a *AuthService) loadSession(r *http.Request) (*models.AdminUser, *models.AdminSession, error) {
+	cookie, err := a.store.Get(r, sessionName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	userID, ok := session.Values[sessionUserIDKey].(int)
-	if !ok {
-		return nil, nil
+	session := a.sessionFromCookie(cookie)
+	if session == nil {
+		return nil, nil, nil
 	}
 
-	username, ok := session.Values[sessionUsernameKey].(string)
-	if !ok {
-		return nil, nil
+	now := time.Now()
+	timeouts := a.SessionTimeouts()
+	if now.Sub(session.LastSeenAt) > timeouts.Idle || now.Sub(session.CreatedAt) > timeouts.Lifetime {
+		if err := a.db.DeleteAdminSession(session.ID); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, nil
+	}
+
+	// Load the account on every request so role changes, disabling and
+	// deletion take effect immediately
+	user, err := a.db.GetAdminUserByID(session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || !user.Enabled {
+		return nil, nil, nil
+	}
+
+	// Avoid a write on every request; the idle timeout only needs minute precision
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := a.db.TouchAdminSession(session.ID, a.clientIPString(r)); err != nil {
+			log.Printf("Error updating admin session: %v", err)
+		}
 	}
 
-	return &models.AdminUser{
-		ID:       userID,
-		Username: username,
-	}, nil
+	return user, session, nil
 }
 
 // API key authentication
@@ -141,8 +222,28 @@ func (a *AuthService) ValidateAPIKey(apiKey string) (*models.APIKey, error) {
 
 // Middleware
 func (a *AuthService) RequireAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return a.RequireAdminRole(models.RoleViewer, next)
+}
+
+// RequireAdminRole rejects admins whose role is less privileged than role.
+func (a *AuthService) RequireAdminRole(role string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := a.GetAdminFromSession(r)
+		var user *models.AdminUser
+		var session *models.AdminSession
+		var err error
+		if username := a.proxyUsername(r); username != "" {
+			user, session, err = a.loadProxySession(w, r, username)
+		} else {
+			user, session, err = a.loadSession(r)
+		}
+		if errors.Is(err, ErrUserDisabled) {
+			http.Error(w, "Your admin account is disabled", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, ErrProxyUsernameUsed) {
+			http.Error(w, "An account with your username already exists that is not managed by the proxy. Ask an owner to remove it.", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			log.Printf("Error checking admin session: %v", err)
 			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
@@ -154,46 +255,112 @@ func (a *AuthService) RequireAdminAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// Admins without 2FA can only reach their account page to enroll
+		// once it is mandatory. Single sign-on and proxy sign-in leave 2FA
+		// to the identity provider.
+		if !user.TOTPEnabled && session.AuthMethod == models.AuthMethodPassword &&
+			!strings.HasPrefix(r.URL.Path, "/admin/account") && a.TwoFactorRequired() {
+			http.Redirect(w, r, "/admin/account?error=Two-factor authentication is required. Set it up to continue.", http.StatusSeeOther)
+			return
+		}
+
+		if !user.HasRole(role) {
+			http.Redirect(w, r, "/admin?error=You do not have permission to do that", http.StatusSeeOther)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), adminUserKey, user)
+		ctx = context.WithValue(ctx, adminSessionKey, session)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
-func (a *AuthService) RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Check X-API-Key header first
-		apiKey := r.Header.Get("X-API-Key")
-		
-		// If not found, check Authorization header
-		if apiKey == "" {
-			auth := r.Header.Get("Authorization")
-			if strings.HasPrefix(auth, "Bearer ") {
-				apiKey = strings.TrimPrefix(auth, "Bearer ")
-			}
+// APIKeyFromRequest extracts the raw API key from the X-API-Key header,
+// falling back to an Authorization bearer token.
+func APIKeyFromRequest(r *http.Request) string {
+	// Check X-API-Key header first
+	apiKey := r.Header.Get("X-API-Key")
+	
+	// If not found, check Authorization header
+	if apiKey == "" {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			apiKey = strings.TrimPrefix(auth, "Bearer ")
 		}
+	}
 
-		if apiKey == "" {
-			http.Error(w, "API key required", http.StatusUnauthorized)
-			return
-		}
+	return apiKey
+}
 
-		key, err := a.ValidateAPIKey(apiKey)
-		if err != nil {
-			log.Printf("Error validating API key: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+// AuthenticateAPIKey validates the request's API key or HMAC signature against scope, the key's
+// origin, referrer and IP restrictions, and its rate limit and quota. On failure it writes the error response and
+// returns nil.
+func (a *AuthService) AuthenticateAPIKey(w http.ResponseWriter, r *http.Request, scope string) *models.APIKey {
+	var key *models.APIKey
+	if IsSignedRequest(r) {
+		key = a.authenticateSignedRequest(w, r)
+	} else {
+		key = a.authenticateBearerKey(w, r)
+	}
+	if key == nil {
+		return nil
+	}
+	analytics.SetAPIKey(r.Context(), key.ID)
 
+	if !key.HasScope(scope) {
+		http.Error(w, "API key lacks required scope: "+scope, http.StatusForbidden)
+		return nil
+	}
+
+	if reason := checkRestrictions(r, key); reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return nil
+	}
+
+	if !a.checkLimits(w, key) {
+		return nil
+	}
+
+	// Update last used timestamp
+	if err := a.db.UpdateAPIKeyLastUsed(key.ID); err != nil {
+		log.Printf("Error updating API key last used: %v", err)
+	}
+
+	return key
+}
+
+// authenticateBearerKey looks up the raw API key sent with the request. On
+// failure it writes a 401 response and returns nil.
+func (a *AuthService) authenticateBearerKey(w http.ResponseWriter, r *http.Request) *models.APIKey {
+	apiKey := APIKeyFromRequest(r)
+	if apiKey == "" {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return nil
+	}
+
+	key, err := a.ValidateAPIKey(apiKey)
+	if err != nil {
+		log.Printf("Error validating API key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+
+	if key == nil {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return nil
+	}
+
+	return key
+}
+
+// RequireAPIKey rejects requests without a valid API key that has been granted scope.
+func (a *AuthService) RequireAPIKey(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := a.AuthenticateAPIKey(w, r, scope)
 		if key == nil {
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
 			return
 		}
 
-		// Update last used timestamp
-		if err := a.db.UpdateAPIKeyLastUsed(key.ID); err != nil {
-			log.Printf("Error updating API key last used: %v", err)
-		}
-
 		ctx := context.WithValue(r.Context(), apiKeyKey, key)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
@@ -208,6 +375,15 @@ func GetAdminFromContext(ctx context.Context) *models.AdminUser {
 	return user
 }
 
+// GetSessionFromContext returns the session of the signed-in admin.
+func GetSessionFromContext(ctx context.Context) *models.AdminSession {
+	session, ok := ctx.Value(adminSessionKey).(*models.AdminSession)
+	if !ok {
+		return nil
+	}
+	return session
+}
+
 func GetAPIKeyFromContext(ctx context.Context) *models.APIKey {
 	key, ok := ctx.Value(apiKeyKey).(*models.APIKey)
 	if !ok {
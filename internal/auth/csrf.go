@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfTokenKey = "csrf_token"
+
+	// CSRFFieldName is the form field that carries the CSRF token. Scripts
+	// can send the token in the CSRFHeaderName header instead.
+	CSRFFieldName  = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// CSRFToken returns the CSRF token for the browser session behind r,
+// creating one if needed. It must be called before anything is written to w.
+func (a *AuthService) CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	session, err := a.store.Get(r, sessionName)
+	if err != nil {
+		log.Printf("Error getting session for CSRF token: %v", err)
+	}
+
+	if token, ok := session.Values[csrfTokenKey].(string); ok && token != "" {
+		return token
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		log.Printf("Error generating CSRF token: %v", err)
+		return ""
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	session.Values[csrfTokenKey] = token
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Error saving CSRF token: %v", err)
+	}
+	return token
+}
+
+// RequireCSRF rejects state-changing requests to the admin interface that
+// come from another origin or don't carry the session's CSRF token.
+// baseURL is accepted as an origin in addition to the request's own host,
+// for deployments behind a proxy that rewrites the Host header.
+func (a *AuthService) RequireCSRF(next http.Handler, baseURL string) http.Handler {
+	var trustedHost string
+	if u, err := url.Parse(baseURL); err == nil {
+		trustedHost = u.Host
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path != "/admin" && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !sameOrigin(r, trustedHost) {
+			log.Printf("Rejected cross-origin %s %s from origin %q", r.Method, r.URL.Path, requestOrigin(r))
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+
+		if !a.validCSRFToken(r) {
+			log.Printf("Rejected %s %s with missing or invalid CSRF token", r.Method, r.URL.Path)
+			http.Error(w, "Invalid or missing CSRF token. Reload the page and try again.", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *AuthService) validCSRFToken(r *http.Request) bool {
+	session, err := a.store.Get(r, sessionName)
+	if err != nil {
+		return false
+	}
+	expected, _ := session.Values[csrfTokenKey].(string)
+	if expected == "" {
+		return false
+	}
+
+	token := r.Header.Get(CSRFHeaderName)
+	if token == "" {
+		token = r.PostFormValue(CSRFFieldName)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// requestOrigin returns the Origin header, falling back to the Referer
+// header for browsers that omit Origin.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	return r.Header.Get("Referer")
+}
+
+// sameOrigin reports whether r was sent from a page on this server. Requests
+// without Origin or Referer headers, such as from scripts, rely on the token alone.
+func sameOrigin(r *http.Request, trustedHost string) bool {
+	origin := requestOrigin(r)
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) || (trustedHost != "" && strings.EqualFold(u.Host, trustedHost))
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequireCSRF(t *testing.T) {
+	a, _ := newTestAuthService(t)
+
+	// Get a session cookie holding a CSRF token
+	rec := httptest.NewRecorder()
+	token := a.CSRFToken(rec, httptest.NewRequest("GET", "http://shufflr.test/admin", nil))
+	if token == "" {
+		t.Fatal("no CSRF token")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("CSRF token was not saved in a cookie")
+	}
+
+	// Reaching the handler is reported as 200
+	handler := a.RequireCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "https://images.example.com")
+
+	form := func(token string) string {
+		return url.Values{CSRFFieldName: {token}}.Encode()
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		header     string
+		origin     string
+		referrer   string
+		withCookie bool
+		want       int
+	}{
+		{"GET is not checked", "GET", "/admin/images", "", "", "https://evil.test", "", false, http.StatusOK},
+		{"HEAD is not checked", "HEAD", "/admin", "", "", "", "", false, http.StatusOK},
+		{"API paths are not checked", "POST", "/api/images", "", "", "https://evil.test", "", false, http.StatusOK},
+		{"path merely starting with admin is not checked", "POST", "/administrator", "", "", "", "", false, http.StatusOK},
+		{"form token", "POST", "/admin/images", form(token), "", "", "", true, http.StatusOK},
+		{"header token", "POST", "/admin/images", "", token, "", "", true, http.StatusOK},
+		{"same origin", "POST", "/admin", form(token), "", "http://shufflr.test", "", true, http.StatusOK},
+		{"base URL origin", "POST", "/admin", form(token), "", "https://images.example.com", "", true, http.StatusOK},
+		{"same-origin referrer", "POST", "/admin", form(token), "", "", "http://shufflr.test/admin/images", true, http.StatusOK},
+		{"cross origin", "POST", "/admin/images", form(token), "", "https://evil.test", "", true, http.StatusForbidden},
+		{"cross-origin referrer", "POST", "/admin/images", form(token), "", "", "https://evil.test/page", true, http.StatusForbidden},
+		{"opaque origin", "POST", "/admin/images", form(token), "", "null", "", true, http.StatusForbidden},
+		{"missing token", "POST", "/admin/images", "", "", "", "", true, http.StatusForbidden},
+		{"wrong token", "POST", "/admin/images", form(token[:len(token)-1] + "x"), "", "", "", true, http.StatusForbidden},
+		{"token without session", "POST", "/admin/images", form(token), "", "", "", false, http.StatusForbidden},
+		{"DELETE is checked", "DELETE", "/admin/images/1", "", "", "", "", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://shufflr.test"+tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.header != "" {
+				r.Header.Set(CSRFHeaderName, tt.header)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referrer != "" {
+				r.Header.Set("Referer", tt.referrer)
+			}
+			if tt.withCookie {
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestCSRFTokenIsStable(t *testing.T) {
+	a, _ := newTestAuthService(t)
+
+	rec := httptest.NewRecorder()
+	token := a.CSRFToken(rec, httptest.NewRequest("GET", "/admin", nil))
+
+	r := httptest.NewRequest("GET", "/admin", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if again := a.CSRFToken(httptest.NewRecorder(), r); again != token {
+		t.Errorf("token changed within a session: %q, then %q", token, again)
+	}
+}
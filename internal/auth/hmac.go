@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"shufflr/client"
+	"shufflr/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxClockSkew is how far a signed request's timestamp may differ from
+	// the server clock.
+	MaxClockSkew = 5 * time.Minute
+
+	maxNonceLength = 128
+)
+
+// nonceCache remembers nonces seen within the clock skew window so a signed
+// request can't be replayed. Older nonces need not be kept because their
+// timestamps are rejected anyway.
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// add records nonce for keyID, reporting false if it was already used.
+func (c *nonceCache) add(keyID int, nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > time.Minute {
+		for k, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	k := strconv.Itoa(keyID) + ":" + nonce
+	if expires, ok := c.seen[k]; ok && now.Before(expires) {
+		return false
+	}
+	c.seen[k] = now.Add(2 * MaxClockSkew)
+	return true
+}
+
+// SetRequestSigningSecret sets the server-side secret that the signing
+// secrets of API keys are derived from. It must be kept outside the
+// database, so that the stored key hashes alone can't be used to sign
+// requests.
+func (a *AuthService) SetRequestSigningSecret(secret []byte) {
+	a.requestSigningSecret = secret
+}
+
+// SigningSecret returns the secret clients sign requests for apiKey with. It
+// is shown once alongside the key, as the server only stores the key's hash.
+func (a *AuthService) SigningSecret(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return a.signingSecretForHash(hex.EncodeToString(hash[:]))
+}
+
+func (a *AuthService) signingSecretForHash(keyHash string) string {
+	mac := hmac.New(sha256.New, a.requestSigningSecret)
+	mac.Write([]byte(keyHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type signedAuthorization struct {
+	keyID     int
+	timestamp string
+	nonce     string
+	signature string
+}
+
+// IsSignedRequest reports whether the request carries an HMAC signature
+// rather than a bearer API key.
+func IsSignedRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), client.Scheme+" ")
+}
+
+// parseSignedAuthorization parses
+// "SHUFFLR-HMAC-SHA256 KeyId=.., Timestamp=.., Nonce=.., Signature=..".
+func parseSignedAuthorization(header string) (*signedAuthorization, bool) {
+	params := strings.TrimPrefix(header, client.Scheme+" ")
+	auth := &signedAuthorization{}
+	for _, part := range strings.Split(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, false
+		}
+		switch name {
+		case "KeyId":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, false
+			}
+			auth.keyID = id
+		case "Timestamp":
+			auth.timestamp = value
+		case "Nonce":
+			auth.nonce = value
+		case "Signature":
+			auth.signature = value
+		}
+	}
+
+	if auth.keyID == 0 || auth.timestamp == "" || auth.nonce == "" || auth.signature == "" || len(auth.nonce) > maxNonceLength {
+		return nil, false
+	}
+	return auth, true
+}
+
+// authenticateSignedRequest verifies an HMAC-signed request and returns its
+// key. On failure it writes a 401 response and returns nil.
+func (a *AuthService) authenticateSignedRequest(w http.ResponseWriter, r *http.Request) *models.APIKey {
+	if len(a.requestSigningSecret) == 0 {
+		http.Error(w, "Request signing is not enabled", http.StatusUnauthorized)
+		return nil
+	}
+
+	sa, ok := parseSignedAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		http.Error(w, "Malformed signed authorization header", http.StatusUnauthorized)
+		return nil
+	}
+
+	unix, err := strconv.ParseInt(sa.timestamp, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid signature timestamp", http.StatusUnauthorized)
+		return nil
+	}
+	now := time.Now()
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		http.Error(w, "Signature timestamp outside allowed clock skew", http.StatusUnauthorized)
+		return nil
+	}
+
+	key, err := a.db.GetAPIKeyByID(sa.keyID)
+	if err != nil {
+		log.Printf("Error getting API key for signed request: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+	if key == nil || !key.Enabled || key.IsExpired() {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return nil
+	}
+
+	// The signing secret is derived from the stored key hash; during
+	// rotation the previous key's secret is accepted as well
+	stringToSign := client.StringToSign(r.Method, r.URL.EscapedPath(), r.URL.Query().Encode(), sa.timestamp, sa.nonce)
+	valid := hmac.Equal([]byte(sa.signature), []byte(client.Signature(a.signingSecretForHash(key.KeyHash), stringToSign)))
+	if !valid && key.InGracePeriod() && key.PreviousKeyHash != "" {
+		valid = hmac.Equal([]byte(sa.signature), []byte(client.Signature(a.signingSecretForHash(key.PreviousKeyHash), stringToSign)))
+	}
+	if !valid {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return nil
+	}
+
+	// Only record the nonce once the signature is known to be genuine
+	if !a.nonces.add(key.ID, sa.nonce, now) {
+		http.Error(w, "Nonce already used", http.StatusUnauthorized)
+		return nil
+	}
+
+	return key
+}
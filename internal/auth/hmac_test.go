@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"shufflr/client"
+	"shufflr/internal/storage"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestAuthService(t *testing.T) (*AuthService, *storage.DB) {
+	t.Helper()
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewAuthService(db, "test-session-secret"), db
+}
+
+// signedRequest builds a request signed like client.RequestSigner does, but
+// with a chosen timestamp and nonce.
+func signedRequest(method, target string, keyID int, signingSecret string, timestamp time.Time, nonce string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	stringToSign := client.StringToSign(r.Method, r.URL.EscapedPath(), r.URL.Query().Encode(), ts, nonce)
+	r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%d, Timestamp=%s, Nonce=%s, Signature=%s",
+		client.Scheme, keyID, ts, nonce, client.Signature(signingSecret, stringToSign)))
+	return r
+}
+
+func TestAuthenticateSignedRequest(t *testing.T) {
+	a, db := newTestAuthService(t)
+	a.SetRequestSigningSecret([]byte("server-signing-secret"))
+
+	key, apiKey, err := db.CreateAPIKey("signed", []string{"images:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := a.SigningSecret(apiKey)
+
+	disabledKey, disabledAPIKey, err := db.CreateAPIKey("disabled", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateAPIKeyEnabled(disabledKey.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expiredKey, expiredAPIKey, err := db.CreateAPIKey("expired", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := db.UpdateAPIKeyExpiry(expiredKey.ID, &past); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated keys accept the previous secret only during the grace period
+	graceKey, oldGraceAPIKey, err := db.CreateAPIKey("grace", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newGraceAPIKey, err := db.RotateAPIKey(graceKey.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, oldRotatedAPIKey, err := db.CreateAPIKey("rotated", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RotateAPIKey(rotatedKey.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stored key hash alone must not be enough to sign requests
+	keyHash := sha256.Sum256([]byte(apiKey))
+	hashSecret := hex.EncodeToString(keyHash[:])
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		wantID  int
+		wantErr string
+	}{
+		{"valid", func() *http.Request {
+			return signedRequest("GET", "/api/images?limit=5&tag=cats", key.ID, secret, now, "n-valid")
+		}, key.ID, ""},
+		{"timestamp within skew", func() *http.Request {
+			return signedRequest("GET", "/api/images", key.ID, secret, now.Add(-MaxClockSkew+time.Minute), "n-skew")
+		}, key.ID, ""},
+		{"previous secret in grace period", func() *http.Request {
+			return signedRequest("GET", "/api/images", graceKey.ID, a.SigningSecret(oldGraceAPIKey), now, "n-grace-old")
+		}, graceKey.ID, ""},
+		{"new secret after rotation", func() *http.Request {
+			return signedRequest("GET", "/api/images", graceKey.ID, a.SigningSecret(newGraceAPIKey), now, "n-grace-new")
+		}, graceKey.ID, ""},
+		{"previous secret without grace period", func() *http.Request {
+			return signedRequest("GET", "/api/images", rotatedKey.ID, a.SigningSecret(oldRotatedAPIKey), now, "n-rotated")
+		}, 0, "Invalid signature"},
+		{"malformed header", func() *http.Request {
+			r := httptest.NewRequest("GET", "/api/images", nil)
+			r.Header.Set("Authorization", client.Scheme+" KeyId=1, Signature=abc")
+			return r
+		}, 0, "Malformed signed authorization header"},
+		{"non-numeric key ID", func() *http.Request {
+			r := httptest.NewRequest("GET", "/api/images", nil)
+			r.Header.Set("Authorization", client.Scheme+" KeyId=x, Timestamp=1, Nonce=n, Signature=abc")
+			return r
+		}, 0, "Malformed signed authorization header"},
+		{"invalid timestamp", func() *http.Request {
+			r := httptest.NewRequest("GET", "/api/images", nil)
+			r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%d, Timestamp=soon, Nonce=n, Signature=abc", client.Scheme, key.ID))
+			return r
+		}, 0, "Invalid signature timestamp"},
+		{"timestamp too old", func() *http.Request {
+			return signedRequest("GET", "/api/images", key.ID, secret, now.Add(-MaxClockSkew-time.Minute), "n-old")
+		}, 0, "Signature timestamp outside allowed clock skew"},
+		{"timestamp too far ahead", func() *http.Request {
+			return signedRequest("GET", "/api/images", key.ID, secret, now.Add(MaxClockSkew+time.Minute), "n-future")
+		}, 0, "Signature timestamp outside allowed clock skew"},
+		{"unknown key", func() *http.Request {
+			return signedRequest("GET", "/api/images", 9999, secret, now, "n-unknown")
+		}, 0, "Invalid API key"},
+		{"disabled key", func() *http.Request {
+			return signedRequest("GET", "/api/images", disabledKey.ID, a.SigningSecret(disabledAPIKey), now, "n-disabled")
+		}, 0, "Invalid API key"},
+		{"expired key", func() *http.Request {
+			return signedRequest("GET", "/api/images", expiredKey.ID, a.SigningSecret(expiredAPIKey), now, "n-expired")
+		}, 0, "Invalid API key"},
+		{"signed with the raw key", func() *http.Request {
+			return signedRequest("GET", "/api/images", key.ID, apiKey, now, "n-raw")
+		}, 0, "Invalid signature"},
+		{"signed with the stored key hash", func() *http.Request {
+			return signedRequest("GET", "/api/images", key.ID, hashSecret, now, "n-hash")
+		}, 0, "Invalid signature"},
+		{"another key's secret", func() *http.Request {
+			return signedRequest("GET", "/api/images", key.ID, a.SigningSecret(newGraceAPIKey), now, "n-other")
+		}, 0, "Invalid signature"},
+		{"tampered query", func() *http.Request {
+			r := signedRequest("GET", "/api/images?limit=5", key.ID, secret, now, "n-query")
+			r.URL.RawQuery = "limit=500"
+			return r
+		}, 0, "Invalid signature"},
+		{"tampered method", func() *http.Request {
+			r := signedRequest("GET", "/api/images", key.ID, secret, now, "n-method")
+			r.Method = "DELETE"
+			return r
+		}, 0, "Invalid signature"},
+		{"replayed nonce", func() *http.Request {
+			return signedRequest("GET", "/api/images?limit=5&tag=cats", key.ID, secret, now, "n-valid")
+		}, 0, "Nonce already used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			got := a.authenticateSignedRequest(rec, tt.request())
+			if tt.wantErr == "" {
+				if got == nil || got.ID != tt.wantID {
+					t.Fatalf("got key %+v, want ID %d (response %d %q)", got, tt.wantID, rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if got != nil {
+				t.Fatalf("got key %d, want rejection", got.ID)
+			}
+			if rec.Code != http.StatusUnauthorized || rec.Body.String() != tt.wantErr+"\n" {
+				t.Errorf("response = %d %q, want 401 %q", rec.Code, rec.Body.String(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSignedRequestDisabled(t *testing.T) {
+	a, db := newTestAuthService(t)
+	key, apiKey, err := db.CreateAPIKey("signed", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a server secret every derived secret would be public
+	rec := httptest.NewRecorder()
+	r := signedRequest("GET", "/api/images", key.ID, a.SigningSecret(apiKey), time.Now(), "n")
+	if got := a.authenticateSignedRequest(rec, r); got != nil {
+		t.Fatal("signed request accepted without a request signing secret")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
+
+func TestSigningSecretDependsOnServerSecret(t *testing.T) {
+	a, _ := newTestAuthService(t)
+	a.SetRequestSigningSecret([]byte("one"))
+	first := a.SigningSecret("key")
+	a.SetRequestSigningSecret([]byte("two"))
+	if second := a.SigningSecret("key"); second == first {
+		t.Error("signing secret did not change with the server secret")
+	}
+	if a.SigningSecret("key") != a.SigningSecret("key") {
+		t.Error("signing secret is not deterministic")
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := newNonceCache()
+	now := time.Now()
+	if !c.add(1, "n", now) {
+		t.Fatal("first use rejected")
+	}
+	if c.add(1, "n", now.Add(time.Minute)) {
+		t.Error("reuse accepted")
+	}
+	if !c.add(2, "n", now) {
+		t.Error("same nonce for another key rejected")
+	}
+	if !c.add(1, "n", now.Add(2*MaxClockSkew+time.Second)) {
+		t.Error("nonce still rejected after it expired")
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// IsHTTPS reports whether the client reached us over HTTPS, either directly
+// or through a TLS-terminating proxy that sets X-Forwarded-Proto.
+func IsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	// With several proxies the first entry is the one the client connected to
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// secureCookieStore sets the Secure flag on session cookies per request, so
+// the same deployment works over plain HTTP locally and over HTTPS.
+type secureCookieStore struct {
+	*sessions.CookieStore
+	// Overrides the per-request detection when set
+	force *bool
+}
+
+func (s *secureCookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *secureCookieStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := s.CookieStore.New(r, name)
+	if s.force != nil {
+		session.Options.Secure = *s.force
+	} else {
+		session.Options.Secure = IsHTTPS(r)
+	}
+	return session, err
+}
+
+// SetSecureCookies always or never marks session cookies Secure instead of
+// deciding per request. Always is useful behind a proxy that terminates TLS
+// without setting X-Forwarded-Proto.
+func (a *AuthService) SetSecureCookies(secure bool) {
+	a.store.force = &secure
+}
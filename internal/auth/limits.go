@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"shufflr/internal/models"
+	"strconv"
+	"time"
+)
+
+// ImageQuota is the remaining image allowance for an API key across its
+// daily and monthly quotas, whichever is tighter.
+type ImageQuota struct {
+	Limited   bool
+	Limit     int
+	Remaining int
+	ResetAt   time.Time
+}
+
+// GetImageQuota computes the remaining image quota for key from logged API requests.
+func (a *AuthService) GetImageQuota(key *models.APIKey) (ImageQuota, error) {
+	var quota ImageQuota
+	now := time.Now().UTC()
+
+	periods := []struct {
+		limit int
+		start time.Time
+		reset time.Time
+	}{
+		{
+			limit: key.DailyImageQuota,
+			start: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+			reset: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			limit: key.MonthlyImageQuota,
+			start: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+			reset: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, period := range periods {
+		if period.limit <= 0 {
+			continue
+		}
+
+		used, err := a.db.GetAPIKeyImageUsageSince(key.ID, period.start)
+		if err != nil {
+			return quota, err
+		}
+
+		remaining := period.limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		if !quota.Limited || remaining < quota.Remaining {
+			quota = ImageQuota{
+				Limited:   true,
+				Limit:     period.limit,
+				Remaining: remaining,
+				ResetAt:   period.reset,
+			}
+		}
+	}
+
+	return quota, nil
+}
+
+// SetQuotaHeaders writes the X-RateLimit-Quota-* headers for quota.
+func SetQuotaHeaders(w http.ResponseWriter, quota ImageQuota) {
+	if !quota.Limited {
+		return
+	}
+	w.Header().Set("X-RateLimit-Quota-Limit", strconv.Itoa(quota.Limit))
+	w.Header().Set("X-RateLimit-Quota-Remaining", strconv.Itoa(quota.Remaining))
+	w.Header().Set("X-RateLimit-Quota-Reset", strconv.FormatInt(quota.ResetAt.Unix(), 10))
+}
+
+// RejectQuotaExceeded writes a 429 response telling the client when its quota resets.
+func RejectQuotaExceeded(w http.ResponseWriter, quota ImageQuota, message string) {
+	SetQuotaHeaders(w, quota)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(time.Until(quota.ResetAt))))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// checkLimits enforces the per-key request rate and image quota, writing a
+// 429 response and returning false if either is exhausted.
+func (a *AuthService) checkLimits(w http.ResponseWriter, key *models.APIKey) bool {
+	result := a.limiter.Allow(key.ID, key.RateLimitPerSecond, key.RateLimitBurst)
+	if key.RateLimitPerSecond > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.ResetAfter).Unix(), 10))
+	}
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(result.RetryAfter)))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+
+	quota, err := a.GetImageQuota(key)
+	if err != nil {
+		log.Printf("Error checking image quota for key %d: %v", key.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if quota.Limited && quota.Remaining <= 0 {
+		RejectQuotaExceeded(w, quota, fmt.Sprintf("Image quota exceeded (%d)", quota.Limit))
+		return false
+	}
+	SetQuotaHeaders(w, quota)
+
+	return true
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"shufflr/internal/models"
+	"time"
+)
+
+// Failed sign-ins are throttled separately per username and per IP address.
+// After the free attempts each further failure doubles the wait before the
+// next try, and reaching the lockout threshold blocks sign-in for
+// loginLockoutDuration. Failures older than loginFailureWindow, or before the
+// last successful sign-in, are not counted.
+const (
+	loginFailureWindow   = time.Hour
+	loginLockoutDuration = 15 * time.Minute
+	maxLoginBackoff      = 5 * time.Minute
+)
+
+type loginThrottle struct {
+	freeAttempts     int
+	lockoutThreshold int
+}
+
+var (
+	usernameThrottle = loginThrottle{freeAttempts: 3, lockoutThreshold: 10}
+	// Higher limits for addresses, which may be shared by several admins
+	ipThrottle = loginThrottle{freeAttempts: 10, lockoutThreshold: 50}
+)
+
+// blockedUntil returns when the next attempt is allowed after failures.
+func (t loginThrottle) blockedUntil(failures *models.LoginFailures) time.Time {
+	if failures == nil || failures.Count <= t.freeAttempts {
+		return time.Time{}
+	}
+	if t.lockedOut(failures) {
+		return failures.LastFailure.Add(loginLockoutDuration)
+	}
+	backoff := time.Duration(math.Pow(2, float64(failures.Count-t.freeAttempts-1))) * time.Second
+	if backoff > maxLoginBackoff {
+		backoff = maxLoginBackoff
+	}
+	return failures.LastFailure.Add(backoff)
+}
+
+func (t loginThrottle) lockedOut(failures *models.LoginFailures) bool {
+	return failures.Count >= t.lockoutThreshold
+}
+
+// Lockout is a username or IP address that has been locked out of signing in.
+type Lockout struct {
+	Username  string
+	IPAddress string
+	Failures  int
+	Until     time.Time
+}
+
+// CheckLoginAllowed returns how long the client must wait before trying to
+// sign in as username, or zero if it may try now.
+func (a *AuthService) CheckLoginAllowed(r *http.Request, username string) (time.Duration, error) {
+	since := time.Now().Add(-loginFailureWindow)
+
+	userFailures, err := a.db.GetUsernameLoginFailures(username, since)
+	if err != nil {
+		return 0, err
+	}
+	until := usernameThrottle.blockedUntil(userFailures)
+
+	if ip := a.clientIPString(r); ip != "" {
+		ipFailures, err := a.db.GetIPLoginFailures(ip, since)
+		if err != nil {
+			return 0, err
+		}
+		if ipUntil := ipThrottle.blockedUntil(ipFailures); ipUntil.After(until) {
+			until = ipUntil
+		}
+	}
+
+	wait := time.Until(until)
+	if wait <= 0 {
+		return 0, nil
+	}
+	log.Printf("Blocked sign-in attempt for %q from %s for %s", username, a.clientIPString(r), wait.Round(time.Second))
+	return wait, nil
+}
+
+// RecordLoginAttempt stores the outcome of a sign-in step for review and throttling.
+func (a *AuthService) RecordLoginAttempt(r *http.Request, username, stage, result string) {
+	if err := a.db.RecordLoginAttempt(username, a.clientIPString(r), stage, result); err != nil {
+		log.Printf("Error recording login attempt: %v", err)
+	}
+}
+
+// GetLockouts returns the usernames and IP addresses that are currently locked out.
+func (a *AuthService) GetLockouts() ([]Lockout, error) {
+	since := time.Now().Add(-loginFailureWindow)
+	now := time.Now()
+	var lockouts []Lockout
+
+	byUsername, err := a.db.GetLoginFailuresByUsername(since)
+	if err != nil {
+		return nil, err
+	}
+	for _, failures := range byUsername {
+		if until := usernameThrottle.blockedUntil(failures); usernameThrottle.lockedOut(failures) && until.After(now) {
+			lockouts = append(lockouts, Lockout{Username: failures.Value, Failures: failures.Count, Until: until})
+		}
+	}
+
+	byIP, err := a.db.GetLoginFailuresByIP(since)
+	if err != nil {
+		return nil, err
+	}
+	for _, failures := range byIP {
+		if until := ipThrottle.blockedUntil(failures); ipThrottle.lockedOut(failures) && until.After(now) {
+			lockouts = append(lockouts, Lockout{IPAddress: failures.Value, Failures: failures.Count, Until: until})
+		}
+	}
+
+	return lockouts, nil
+}
+
+// Unlock clears earlier failed sign-ins for a username or IP address.
+func (a *AuthService) Unlock(username, ipAddress string) error {
+	return a.db.RecordLoginAttempt(username, ipAddress, "", models.LoginResultUnlocked)
+}
+
+func (a *AuthService) clientIPString(r *http.Request) string {
+	if ip := a.clientIP(r); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"shufflr/internal/models"
+	"shufflr/internal/oidc"
+	"strings"
+	"time"
+)
+
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+	oidcExpiresKey  = "oidc_expires"
+
+	// How long the user has to complete sign-in at the provider
+	oidcLoginTTL = 10 * time.Minute
+)
+
+// Errors from FinishOIDCLogin caused by the user's account rather than a
+// fault, which the login page explains.
+var (
+	ErrOIDCLoginExpired = errors.New("single sign-on state missing or expired")
+	ErrOIDCNoRole       = errors.New("no admin role mapped from single sign-on claims")
+	ErrOIDCUsernameUsed = errors.New("username belongs to an existing local account")
+)
+
+// OIDCOptions configures single sign-on through an OpenID Connect provider.
+type OIDCOptions struct {
+	Provider *oidc.Provider
+
+	// Claim holding the username for new accounts; falls back to email and sub
+	UsernameClaim string
+	// Claim holding group or role names, e.g. "groups" or "realm_access.roles"
+	RoleClaim string
+	// Maps values of RoleClaim to admin roles; the most privileged match wins
+	RoleMapping map[string]string
+	// Role for users without a mapped value; empty denies them access
+	DefaultRole string
+
+	// Only allow signing in through the provider
+	DisablePasswordLogin bool
+}
+
+// EnableOIDC turns on single sign-on.
+func (a *AuthService) EnableOIDC(options OIDCOptions) {
+	if options.UsernameClaim == "" {
+		options.UsernameClaim = "preferred_username"
+	}
+	a.oidc = &options
+}
+
+// OIDCEnabled reports whether single sign-on is configured.
+func (a *AuthService) OIDCEnabled() bool {
+	return a.oidc != nil
+}
+
+// PasswordLoginEnabled reports whether admins may sign in with a password.
+// It can only be turned off when single sign-on is configured.
+func (a *AuthService) PasswordLoginEnabled() bool {
+	return a.oidc == nil || !a.oidc.DisablePasswordLogin
+}
+
+// ParseRoleMapping parses a comma-separated list of value=role pairs, e.g.
+// "shufflr-admins=owner,shufflr-editors=editor".
+func ParseRoleMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		claim, role, ok := strings.Cut(pair, "=")
+		claim, role = strings.TrimSpace(claim), strings.TrimSpace(role)
+		if !ok || claim == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, expected value=role", pair)
+		}
+		if !models.IsValidRole(role) {
+			return nil, fmt.Errorf("invalid role %q in role mapping", role)
+		}
+		mapping[claim] = role
+	}
+	return mapping, nil
+}
+
+// StartOIDCLogin begins the authorization code flow and returns the provider
+// URL to redirect the browser to.
+func (a *AuthService) StartOIDCLogin(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := a.store.Get(r, sessionName)
+	if err != nil {
+		return "", err
+	}
+
+	var values [3]string
+	for i := range values {
+		if values[i], err = oidc.RandomString(); err != nil {
+			return "", err
+		}
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := a.oidc.Provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	session.Values[oidcStateKey] = state
+	session.Values[oidcNonceKey] = nonce
+	session.Values[oidcVerifierKey] = verifier
+	session.Values[oidcExpiresKey] = time.Now().Add(oidcLoginTTL).Unix()
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+
+	return authURL, nil
+}
+
+// FinishOIDCLogin handles the provider's redirect back to us: it redeems the
+// code, verifies the ID token, finds or creates the matching admin user with
+// a role from the configured mapping, and signs them in.
+func (a *AuthService) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) (*models.AdminUser, error) {
+	session, err := a.store.Get(r, sessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	state, _ := session.Values[oidcStateKey].(string)
+	nonce, _ := session.Values[oidcNonceKey].(string)
+	verifier, _ := session.Values[oidcVerifierKey].(string)
+	expires, _ := session.Values[oidcExpiresKey].(int64)
+
+	// The state is single use
+	delete(session.Values, oidcStateKey)
+	delete(session.Values, oidcNonceKey)
+	delete(session.Values, oidcVerifierKey)
+	delete(session.Values, oidcExpiresKey)
+	if err := session.Save(r, w); err != nil {
+		return nil, err
+	}
+
+	if state == "" || r.URL.Query().Get("state") != state || time.Now().Unix() > expires {
+		return nil, ErrOIDCLoginExpired
+	}
+	if providerErr := r.URL.Query().Get("error"); providerErr != "" {
+		return nil, fmt.Errorf("provider returned error %s: %s", providerErr, r.URL.Query().Get("error_description"))
+	}
+
+	tokens, err := a.oidc.Provider.Exchange(r.Context(), r.URL.Query().Get("code"), verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := a.oidc.Provider.VerifyIDToken(r.Context(), tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	subject := claims.String("sub")
+
+	// Providers often only return groups from the userinfo endpoint
+	if a.oidc.RoleClaim != "" && claims.Strings(a.oidc.RoleClaim) == nil {
+		info, err := a.oidc.Provider.UserInfo(r.Context(), tokens.AccessToken)
+		if err != nil {
+			log.Printf("Error fetching OIDC user info: %v", err)
+		} else if info != nil && info.String("sub") == subject {
+			for name, value := range info {
+				if _, ok := claims[name]; !ok {
+					claims[name] = value
+				}
+			}
+		}
+	}
+
+	username := a.oidcUsername(claims)
+	role := a.oidcRole(claims)
+	if role == "" {
+		a.RecordLoginAttempt(r, username, models.LoginStageOIDC, models.LoginResultFailure)
+		return nil, ErrOIDCNoRole
+	}
+
+	user, err := a.db.GetAdminUserByOIDCSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		existing, err := a.db.GetAdminUserByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			a.RecordLoginAttempt(r, username, models.LoginStageOIDC, models.LoginResultFailure)
+			return nil, ErrOIDCUsernameUsed
+		}
+		if user, err = a.db.CreateOIDCAdminUser(username, subject, role); err != nil {
+			return nil, err
+		}
+		log.Printf("Created admin user %s (%s) from single sign-on", user.Username, user.Role)
+	} else if user.Role != role {
+		// The provider is the source of truth for roles of SSO accounts
+		if err := a.db.UpdateAdminUserRole(user.ID, role); err != nil {
+			return nil, err
+		}
+		log.Printf("Changed role of admin user %s from %s to %s based on single sign-on claims", user.Username, user.Role, role)
+		user.Role = role
+	}
+
+	if !user.Enabled {
+		a.RecordLoginAttempt(r, user.Username, models.LoginStageOIDC, models.LoginResultFailure)
+		return nil, ErrUserDisabled
+	}
+
+	if err := a.SetAdminSession(w, r, user, models.AuthMethodOIDC); err != nil {
+		return nil, err
+	}
+	a.RecordLoginAttempt(r, user.Username, models.LoginStageOIDC, models.LoginResultSuccess)
+	return user, nil
+}
+
+func (a *AuthService) oidcUsername(claims oidc.Claims) string {
+	for _, name := range []string{a.oidc.UsernameClaim, "email", "sub"} {
+		if value := claims.String(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// oidcRole returns the most privileged role mapped from the user's claims,
+// or the default role if none match.
+func (a *AuthService) oidcRole(claims oidc.Claims) string {
+	best := ""
+	if a.oidc.RoleClaim != "" {
+		for _, value := range claims.Strings(a.oidc.RoleClaim) {
+			if role, ok := a.oidc.RoleMapping[value]; ok && !models.RoleAtLeast(best, role) {
+				best = role
+			}
+		}
+	}
+	if best == "" {
+		best = a.oidc.DefaultRole
+	}
+	return best
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"shufflr/internal/models"
+	"strings"
+)
+
+// ErrProxyUsernameUsed is returned when a proxy asserts the username of an
+// account it didn't create, such as a password or single sign-on account.
+// Those keep their own credentials and two-factor authentication.
+var ErrProxyUsernameUsed = errors.New("username belongs to an account not managed by the proxy")
+
+// ProxyAuthOptions configures trusting the username set by an authenticating
+// reverse proxy such as oauth2-proxy or Authelia.
+type ProxyAuthOptions struct {
+	// Header holding the authenticated username, e.g. "X-Forwarded-User"
+	Header string
+	// Only requests from these networks may set Header; it is ignored on
+	// requests from anywhere else
+	TrustedProxies []*net.IPNet
+	// Role for users created on their first request
+	DefaultRole string
+}
+
+// EnableProxyAuth turns on reverse-proxy header authentication.
+func (a *AuthService) EnableProxyAuth(options ProxyAuthOptions) {
+	options.Header = http.CanonicalHeaderKey(options.Header)
+	a.proxy = &options
+}
+
+// proxyUsername returns the username asserted by a trusted proxy, or "" if
+// the request did not come through one.
+func (a *AuthService) proxyUsername(r *http.Request) string {
+	if a.proxy == nil {
+		return ""
+	}
+	// RemoteAddr is the direct peer; forwarding headers can be forged
+	ip := ClientIP(r)
+	if ip == nil || !containsIP(a.proxy.TrustedProxies, ip) {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(a.proxy.Header))
+}
+
+// clientIP returns the address of the client that sent r. Requests from a
+// trusted proxy are attributed to the last address in X-Forwarded-For that
+// isn't itself a trusted proxy, so sign-in throttling and session listings
+// see each admin's own address rather than the proxy's.
+func (a *AuthService) clientIP(r *http.Request) net.IP {
+	ip := ClientIP(r)
+	if a.proxy == nil || ip == nil || !containsIP(a.proxy.TrustedProxies, ip) {
+		return ip
+	}
+
+	// Only the entries appended by trusted proxies can be relied on, so walk
+	// the header from the right
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(a.proxy.TrustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// loadProxySession signs in the user named by a trusted proxy, creating the
+// account on first use. Only accounts the proxy created can be signed in
+// this way. The current session is kept while it belongs to the same user,
+// so session listing and revocation work as for password logins.
+func (a *AuthService) loadProxySession(w http.ResponseWriter, r *http.Request, username string) (*models.AdminUser, *models.AdminSession, error) {
+	user, session, err := a.loadSession(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user != nil && user.Username == username && user.ProxyAuth {
+		return user, session, nil
+	}
+
+	user, err = a.db.GetAdminUserByUsername(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		if user, err = a.db.CreateProxyAdminUser(username, a.proxy.DefaultRole); err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Created admin user %s (%s) from proxy header %s", user.Username, user.Role, a.proxy.Header)
+	} else if !user.ProxyAuth {
+		a.RecordLoginAttempt(r, user.Username, models.LoginStageProxy, models.LoginResultFailure)
+		return nil, nil, ErrProxyUsernameUsed
+	}
+
+	if !user.Enabled {
+		a.RecordLoginAttempt(r, user.Username, models.LoginStageProxy, models.LoginResultFailure)
+		return nil, nil, ErrUserDisabled
+	}
+
+	session, err = a.startSession(w, r, user, models.AuthMethodProxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.RecordLoginAttempt(r, user.Username, models.LoginStageProxy, models.LoginResultSuccess)
+	return user, session, nil
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"shufflr/internal/models"
+	"strings"
+)
+
+// checkRestrictions enforces a key's origin, referrer and IP allowlists,
+// returning a message describing the first violated restriction.
+func checkRestrictions(r *http.Request, key *models.APIKey) string {
+	if len(key.AllowedOrigins) > 0 {
+		// Requests without an Origin are rejected like those without a
+		// Referer, or any non-browser client could use the key
+		origin := r.Header.Get("Origin")
+		if origin == "" || !MatchOrigin(key.AllowedOrigins, origin) {
+			return "Origin not allowed for this API key"
+		}
+	}
+
+	if len(key.AllowedReferrers) > 0 {
+		referrer := r.Header.Get("Referer")
+		if referrer == "" || !matchAnyWildcard(key.AllowedReferrers, referrer) {
+			return "Referrer not allowed for this API key"
+		}
+	}
+
+	if len(key.AllowedIPs) > 0 {
+		networks, err := ParseIPAllowlist(key.AllowedIPs)
+		if err != nil {
+			return "Invalid IP allowlist for this API key"
+		}
+		ip := ClientIP(r)
+		if ip == nil || !containsIP(networks, ip) {
+			return "IP address not allowed for this API key"
+		}
+	}
+
+	return ""
+}
+
+// ClientIP returns the address of the peer that sent r.
+func ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// MatchOrigin reports whether origin matches one of patterns. Patterns are
+// full origins such as "https://example.com" and may use * as a wildcard,
+// e.g. "https://*.example.com".
+func MatchOrigin(patterns []string, origin string) bool {
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), "/")
+		if pattern == "*" || matchWildcard(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIPAllowlist parses CIDR ranges and bare IP addresses.
+func ParseIPAllowlist(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range: %s", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ValidateOrigins checks that each entry is an http(s) origin without a path.
+func ValidateOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "*", "wildcard", -1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return fmt.Errorf("invalid origin: %s", origin)
+		}
+	}
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyWildcard(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard matches value against pattern, where * matches any sequence of characters.
+func matchWildcard(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
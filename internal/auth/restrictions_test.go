@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"shufflr/internal/models"
+	"testing"
+)
+
+func TestCheckRestrictions(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        models.APIKey
+		origin     string
+		referrer   string
+		remoteAddr string
+		want       string
+	}{
+		{"unrestricted", models.APIKey{}, "", "", "", ""},
+		{"origin allowed", models.APIKey{AllowedOrigins: []string{"https://example.com"}}, "https://example.com", "", "", ""},
+		{"origin case and trailing slash", models.APIKey{AllowedOrigins: []string{"https://Example.com/"}}, "https://example.COM", "", "", ""},
+		{"origin wildcard subdomain", models.APIKey{AllowedOrigins: []string{"https://*.example.com"}}, "https://app.example.com", "", "", ""},
+		{"origin wildcard doesn't match the apex", models.APIKey{AllowedOrigins: []string{"https://*.example.com"}}, "https://example.com", "", "", "Origin not allowed for this API key"},
+		{"origin star", models.APIKey{AllowedOrigins: []string{"*"}}, "https://anywhere.test", "", "", ""},
+		{"origin mismatch", models.APIKey{AllowedOrigins: []string{"https://example.com"}}, "https://evil.test", "", "", "Origin not allowed for this API key"},
+		{"origin scheme mismatch", models.APIKey{AllowedOrigins: []string{"https://example.com"}}, "http://example.com", "", "", "Origin not allowed for this API key"},
+		{"origin suffix attack", models.APIKey{AllowedOrigins: []string{"https://example.com"}}, "https://example.com.evil.test", "", "", "Origin not allowed for this API key"},
+		{"origin missing", models.APIKey{AllowedOrigins: []string{"https://example.com"}}, "", "", "", "Origin not allowed for this API key"},
+		{"origin missing even with star", models.APIKey{AllowedOrigins: []string{"*"}}, "", "", "", "Origin not allowed for this API key"},
+		{"referrer allowed", models.APIKey{AllowedReferrers: []string{"https://example.com/gallery/*"}}, "", "https://example.com/gallery/1", "", ""},
+		{"referrer mismatch", models.APIKey{AllowedReferrers: []string{"https://example.com/gallery/*"}}, "", "https://example.com/other", "", "Referrer not allowed for this API key"},
+		{"referrer missing", models.APIKey{AllowedReferrers: []string{"https://example.com/*"}}, "", "", "", "Referrer not allowed for this API key"},
+		{"IP allowed", models.APIKey{AllowedIPs: []string{"192.0.2.10"}}, "", "", "192.0.2.10:1234", ""},
+		{"IP in range", models.APIKey{AllowedIPs: []string{"10.0.0.0/8"}}, "", "", "10.1.2.3:1234", ""},
+		{"IPv6 in range", models.APIKey{AllowedIPs: []string{"2001:db8::/32"}}, "", "", "[2001:db8::1]:1234", ""},
+		{"IP outside range", models.APIKey{AllowedIPs: []string{"10.0.0.0/8"}}, "", "", "192.0.2.10:1234", "IP address not allowed for this API key"},
+		{"invalid allowlist", models.APIKey{AllowedIPs: []string{"not-an-ip"}}, "", "", "192.0.2.10:1234", "Invalid IP allowlist for this API key"},
+		{"origin checked first", models.APIKey{AllowedOrigins: []string{"https://example.com"}, AllowedIPs: []string{"10.0.0.0/8"}}, "https://evil.test", "", "192.0.2.10:1234", "Origin not allowed for this API key"},
+		{"all restrictions pass", models.APIKey{
+			AllowedOrigins:   []string{"https://example.com"},
+			AllowedReferrers: []string{"https://example.com/*"},
+			AllowedIPs:       []string{"192.0.2.0/24"},
+		}, "https://example.com", "https://example.com/page", "192.0.2.10:1234", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/images", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referrer != "" {
+				r.Header.Set("Referer", tt.referrer)
+			}
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if got := checkRestrictions(r, &tt.key); got != tt.want {
+				t.Errorf("checkRestrictions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOrigins(t *testing.T) {
+	tests := []struct {
+		origin  string
+		wantErr bool
+	}{
+		{"*", false},
+		{"https://example.com", false},
+		{"http://localhost:8080", false},
+		{"https://*.example.com", false},
+		{"https://example.com/", false},
+		{"ftp://example.com", true},
+		{"example.com", true},
+		{"https://example.com/path", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateOrigins([]string{tt.origin}); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateOrigins(%q) = %v, want error %v", tt.origin, err, tt.wantErr)
+		}
+	}
+}
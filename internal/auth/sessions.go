@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"log"
+	"shufflr/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	defaultSessionIdleTimeout = 60 * time.Minute
+	defaultSessionLifetime    = 24 * time.Hour
+
+	// How often a session's last-seen time is written back
+	sessionTouchInterval = time.Minute
+)
+
+// SessionTimeouts are the limits on how long an admin session stays valid.
+// A session ends when it has been idle for Idle or is older than Lifetime.
+type SessionTimeouts struct {
+	Idle     time.Duration
+	Lifetime time.Duration
+}
+
+// SessionTimeouts reads the configured session timeouts, falling back to the
+// defaults for missing or invalid settings.
+func (a *AuthService) SessionTimeouts() SessionTimeouts {
+	return SessionTimeouts{
+		Idle:     a.durationSetting("session_idle_timeout_minutes", time.Minute, defaultSessionIdleTimeout),
+		Lifetime: a.durationSetting("session_lifetime_hours", time.Hour, defaultSessionLifetime),
+	}
+}
+
+func (a *AuthService) durationSetting(key string, unit, fallback time.Duration) time.Duration {
+	value, err := a.db.GetSetting(key)
+	if err != nil {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fallback
+	}
+	return time.Duration(n) * unit
+}
+
+// GetActiveSessions lists unexpired sessions for userID, or for every admin
+// if userID is 0.
+func (a *AuthService) GetActiveSessions(userID int) ([]*models.AdminSession, error) {
+	timeouts := a.SessionTimeouts()
+	now := time.Now()
+	return a.db.GetActiveAdminSessions(userID, now.Add(-timeouts.Idle), now.Add(-timeouts.Lifetime))
+}
+
+// sessionFromCookie looks up the server-side session the cookie refers to.
+func (a *AuthService) sessionFromCookie(cookie *sessions.Session) *models.AdminSession {
+	token, ok := cookie.Values[sessionTokenKey].(string)
+	if !ok || token == "" {
+		return nil
+	}
+
+	session, err := a.db.GetAdminSessionByToken(token)
+	if err != nil {
+		log.Printf("Error getting admin session: %v", err)
+		return nil
+	}
+	return session
+}
+
+// pruneSessions deletes expired sessions so the table doesn't grow without bound.
+func (a *AuthService) pruneSessions(timeouts SessionTimeouts) {
+	now := time.Now()
+	if err := a.db.DeleteExpiredAdminSessions(now.Add(-timeouts.Idle), now.Add(-timeouts.Lifetime)); err != nil {
+		log.Printf("Error deleting expired admin sessions: %v", err)
+	}
+}
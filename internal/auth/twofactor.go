@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http"
+	"shufflr/internal/models"
+	"shufflr/internal/totp"
+	"time"
+)
+
+const (
+	pendingUserIDKey  = "pending_user_id"
+	pendingVersionKey = "pending_session_version"
+	pendingExpiresKey = "pending_expires"
+
+	// How long a user has to enter their code after the password step
+	pendingTwoFactorTTL = 5 * time.Minute
+)
+
+// TwoFactorRequired reports whether owners have made 2FA mandatory for every admin.
+func (a *AuthService) TwoFactorRequired() bool {
+	value, err := a.db.GetSetting("require_2fa")
+	return err == nil && value == "true"
+}
+
+// SetPendingTwoFactor records that user passed the password step and still
+// has to enter a second factor. The user is not signed in until
+// SetAdminSession is called.
+func (a *AuthService) SetPendingTwoFactor(w http.ResponseWriter, r *http.Request, user *models.AdminUser) error {
+	session, err := a.store.Get(r, sessionName)
+	if err != nil {
+		return err
+	}
+
+	delete(session.Values, sessionTokenKey)
+	session.Values[pendingUserIDKey] = user.ID
+	session.Values[pendingVersionKey] = user.SessionVersion
+	session.Values[pendingExpiresKey] = time.Now().Add(pendingTwoFactorTTL).Unix()
+
+	return session.Save(r, w)
+}
+
+// GetPendingTwoFactor returns the user waiting on the second login step, or
+// nil if there is none or it has expired.
+func (a *AuthService) GetPendingTwoFactor(r *http.Request) (*models.AdminUser, error) {
+	session, err := a.store.Get(r, sessionName)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, ok := session.Values[pendingUserIDKey].(int)
+	if !ok {
+		return nil, nil
+	}
+	expires, _ := session.Values[pendingExpiresKey].(int64)
+	if time.Now().Unix() > expires {
+		return nil, nil
+	}
+
+	user, err := a.db.GetAdminUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	version, _ := session.Values[pendingVersionKey].(int)
+	if user == nil || !user.Enabled || !user.TOTPEnabled || version != user.SessionVersion {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, an unused recovery
+// code. Each TOTP code and recovery code is accepted only once.
+func (a *AuthService) VerifySecondFactor(user *models.AdminUser, code string) (bool, error) {
+	if counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastCounter); ok {
+		return a.db.UseTOTPCounter(user.ID, counter)
+	}
+
+	if totp.NormalizeRecoveryCode(code) == "" {
+		return false, nil
+	}
+	return a.db.UseRecoveryCode(user.ID, code)
+}
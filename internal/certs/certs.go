@@ -0,0 +1,109 @@
+// Package certs serves a TLS certificate from files and picks up renewed
+// certificates without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the files are checked for changes
+const checkInterval = time.Minute
+
+// Reloader holds the certificate loaded from certFile and keyFile and
+// reloads it when either file changes, e.g. after a certbot renewal.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTimes  [2]time.Time
+	checkedAt time.Time
+}
+
+// NewReloader loads the certificate and key, failing if they are invalid.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate and key from disk. The previous certificate
+// stays in use if they can't be loaded.
+func (r *Reloader) Reload() error {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	r.checkedAt = time.Now()
+	r.mu.Unlock()
+
+	log.Printf("Loaded TLS certificate for %v, expires %s", leaf.DNSNames, leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	due := time.Since(r.checkedAt) > checkInterval
+	if due {
+		r.checkedAt = time.Now()
+	}
+	known := r.modTimes
+	r.mu.Unlock()
+
+	if due {
+		// A half-written renewal fails to load and is retried on the next check
+		if modTimes, err := r.fileModTimes(); err == nil && modTimes != known {
+			if err := r.Reload(); err != nil {
+				log.Printf("Error reloading TLS certificate: %v", err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// TLSConfig returns a server configuration that serves the current certificate.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+func (r *Reloader) fileModTimes() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, fmt.Errorf("failed to read TLS certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
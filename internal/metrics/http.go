@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	httpRequests = NewCounter("shufflr_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "status")
+	httpRequestDuration = NewHistogram("shufflr_http_request_duration_seconds",
+		"Time taken to answer HTTP requests by route, method and status code.", DefaultBuckets, "route", "method", "status")
+)
+
+// Middleware records the count and latency of requests handled by next.
+// Requests are labelled with the mux pattern they match rather than their
+// path, so the number of series stays bounded.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		status := strconv.Itoa(sw.status)
+		method := methodLabel(r.Method)
+		httpRequests.Inc(route, method, status)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route, method, status)
+	})
+}
+
+// methodLabel returns method if it is a standard HTTP method and "other"
+// otherwise. Any token is accepted as a method, so using it as is would let
+// clients create series without limit.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// RequireToken rejects requests that don't send token as a bearer token.
+// An empty token lets every request through.
+func RequireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter remembers the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ByteCounter wraps w so the bytes written to it are added to counter.
+func ByteCounter(w http.ResponseWriter, counter *Counter) http.ResponseWriter {
+	return &countingWriter{ResponseWriter: w, counter: counter}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	counter *Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.counter.Add(float64(n))
+	return n, err
+}
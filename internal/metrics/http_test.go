@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{http.MethodGet, "GET"},
+		{http.MethodPost, "POST"},
+		{http.MethodOptions, "OPTIONS"},
+		{"get", "other"},
+		{"PROPFIND", "other"},
+		{"X-RANDOM-123", "other"},
+	}
+	for _, tt := range tests {
+		if got := methodLabel(tt.method); got != tt.want {
+			t.Errorf("methodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-middleware/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := Middleware(mux, mux)
+
+	for _, method := range []string{"BREW", "WHEN"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/test-middleware/pot/1", nil))
+	}
+
+	// Both requests share one series, labelled by pattern rather than path
+	want := `shufflr_http_requests_total{route="/test-middleware/",method="other",status="418"} 2`
+	if got := writeString(httpRequests); !strings.Contains(got, want+"\n") {
+		t.Errorf("missing %q in:\n%s", want, got)
+	}
+	want = `shufflr_http_request_duration_seconds_count{route="/test-middleware/",method="other",status="418"} 2`
+	if got := writeString(httpRequestDuration); !strings.Contains(got, want+"\n") {
+		t.Errorf("missing %q in:\n%s", want, got)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"correct token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"missing header", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			RequireToken(tt.token, ok).ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
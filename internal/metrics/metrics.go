@@ -0,0 +1,282 @@
+// Package metrics keeps counters, histograms and gauges and exposes them in
+// the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds suited to HTTP
+// request latency.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is a metric that can write its current values.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, existing := range registry {
+		if existing.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+	registry = append(registry, c)
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		collectors := append([]collector(nil), registry...)
+		registryMu.Unlock()
+		sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		for _, c := range collectors {
+			c.write(buf)
+		}
+		buf.Flush()
+	})
+}
+
+// vec holds the series of a metric, one per combination of label values.
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string][]string
+}
+
+func (v *vec) init(name, help, kind string, labels []string) {
+	v.metricName, v.help, v.kind, v.labels = name, help, kind, labels
+	v.series = make(map[string][]string)
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// key returns the series key of labelValues. The caller holds v.mu.
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := v.series[key]; !ok {
+		v.series[key] = append([]string(nil), labelValues...)
+	}
+	return key
+}
+
+// sortedKeys returns the series keys in a stable order. The caller holds
+// v.mu.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, escapeHelp(v.help), v.metricName, v.kind)
+}
+
+// labelString formats label names and values as {a="1",b="2"}, with extra
+// appended after the metric's own labels.
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extra[i], escapeLabel(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, optionally split by labels.
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{values: make(map[string]float64)}
+	c.init(name, help, "counter", labels)
+	register(c)
+	return c
+}
+
+// Inc adds one to the series with labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the series with
+// labelValues.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.metricName + " cannot decrease")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += delta
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.metricName)
+		return
+	}
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labelString(c.labels, c.series[key]), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram counts observations into buckets, optionally split by labels.
+type Histogram struct {
+	vec
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// in increasing order, and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{buckets: buckets, values: make(map[string]*histogramValue)}
+	h.init(name, help, "histogram", labels)
+	register(h)
+	return h
+}
+
+// Observe records value in the series with labelValues.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+			break
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		values := h.series[key]
+		v := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += v.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labels, values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labels, values, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labelString(h.labels, values), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labelString(h.labels, values), v.count)
+	}
+}
+
+// GaugeFunc is a value read when metrics are scraped.
+type GaugeFunc struct {
+	vec
+	fn func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn. If fn fails
+// the gauge is left out of the scrape.
+func NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{fn: fn}
+	g.init(name, help, "gauge", nil)
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	value, err := g.fn()
+	if err != nil {
+		log.Printf("Error reading metric %s: %v", g.metricName, err)
+		return
+	}
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(value))
+}
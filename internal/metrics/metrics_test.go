@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test metrics are registered once, as registering a name twice panics.
+var (
+	testCounter = NewCounter("test_requests_total", "Requests with \\ and\nnewline.", "path", "code")
+	testPlain   = NewCounter("test_plain_total", "Unlabelled counter.")
+	testLatency = NewHistogram("test_latency_seconds", "Latency.", []float64{1, 2, 5}, "route")
+	testGauge   = NewGaugeFunc("test_gauge", "Gauge.", func() (float64, error) { return 2.5, nil })
+	testFailing = NewGaugeFunc("test_failing_gauge", "Failing gauge.", func() (float64, error) { return 0, errors.New("unavailable") })
+)
+
+func writeString(c collector) string {
+	var b strings.Builder
+	c.write(&b)
+	return b.String()
+}
+
+func TestCounterWrite(t *testing.T) {
+	testCounter.Inc(`C:\dir`, "200")
+	testCounter.Add(2, `say "hi"`+"\n", "500")
+	testCounter.Inc(`C:\dir`, "200")
+
+	want := `# HELP test_requests_total Requests with \\ and\nnewline.
+# TYPE test_requests_total counter
+test_requests_total{path="C:\\dir",code="200"} 2
+test_requests_total{path="say \"hi\"\n",code="500"} 2
+`
+	if got := writeString(testCounter); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	want := "# HELP test_plain_total Unlabelled counter.\n# TYPE test_plain_total counter\ntest_plain_total 0\n"
+	if got := writeString(testPlain); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"negative delta", func() { testCounter.Add(-1, "a", "b") }},
+		{"missing label value", func() { testCounter.Inc("a") }},
+		{"duplicate name", func() { NewCounter("test_plain_total", "Duplicate.") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	// Values on a bucket's upper bound fall into that bucket
+	for _, v := range []float64{0.5, 1, 1.5, 3, 10} {
+		testLatency.Observe(v, "/api/images")
+	}
+
+	want := `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/api/images",le="1"} 2
+test_latency_seconds_bucket{route="/api/images",le="2"} 3
+test_latency_seconds_bucket{route="/api/images",le="5"} 4
+test_latency_seconds_bucket{route="/api/images",le="+Inf"} 5
+test_latency_seconds_sum{route="/api/images"} 16
+test_latency_seconds_count{route="/api/images"} 5
+`
+	if got := writeString(testLatency); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncWrite(t *testing.T) {
+	want := "# HELP test_gauge Gauge.\n# TYPE test_gauge gauge\ntest_gauge 2.5\n"
+	if got := writeString(testGauge); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := writeString(testFailing); got != "" {
+		t.Errorf("failing gauge wrote %q, want nothing", got)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{0.005, "0.005"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.in); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Metrics are written sorted by name
+	body := rec.Body.String()
+	names := []string{"# TYPE test_gauge ", "# TYPE test_latency_seconds ", "# TYPE test_plain_total ", "# TYPE test_requests_total "}
+	last := -1
+	for _, name := range names {
+		i := strings.Index(body, name)
+		if i < 0 {
+			t.Fatalf("%q missing from output", name)
+		}
+		if i < last {
+			t.Errorf("%q is out of order", name)
+		}
+		last = i
+	}
+	if strings.Contains(body, "test_failing_gauge") {
+		t.Error("failing gauge should be left out")
+	}
+}
@@ -4,11 +4,157 @@ import (
 	"time"
 )
 
+// API key scopes
+const (
+	ScopeImagesRead  = "images:read"
+	ScopeImagesWrite = "images:write"
+	ScopeKeysManage  = "keys:manage"
+	ScopeStatsRead   = "stats:read"
+)
+
+// AllScopes lists every scope an API key can be granted, in display order.
+var AllScopes = []string{
+	ScopeImagesRead,
+	ScopeImagesWrite,
+	ScopeKeysManage,
+	ScopeStatsRead,
+}
+
+// IsValidScope reports whether scope is one of AllScopes.
+func IsValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin user roles, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// AllRoles lists every admin role in display order.
+var AllRoles = []string{
+	RoleOwner,
+	RoleEditor,
+	RoleViewer,
+}
+
+// IsValidRole reports whether role is one of AllRoles.
+func IsValidRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+// RoleAtLeast reports whether role is at least as privileged as other.
+// Unknown roles rank below every valid role.
+func RoleAtLeast(role, other string) bool {
+	return roleRank(role) >= roleRank(other)
+}
+
+func roleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleEditor:
+		return 2
+	case RoleViewer:
+		return 1
+	}
+	return 0
+}
+
 type AdminUser struct {
 	ID           int       `json:"id"`
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	Enabled      bool      `json:"enabled"`
 	CreatedAt    time.Time `json:"created_at"`
+
+	// Incremented on password change to invalidate pending sign-ins
+	SessionVersion int `json:"-"`
+
+	// TOTP two-factor authentication; the secret is set but not enabled
+	// while enrollment is in progress
+	TOTPSecret      string `json:"-"`
+	TOTPEnabled     bool   `json:"totp_enabled"`
+	TOTPLastCounter int64  `json:"-"`
+
+	// Subject of the linked OpenID Connect identity, if the account signs
+	// in through single sign-on
+	OIDCSubject string `json:"-"`
+
+	// Set if the account was created by an authenticating reverse proxy,
+	// which is then trusted to sign it in
+	ProxyAuth bool `json:"-"`
+}
+
+// IsSSO reports whether the user signs in through single sign-on rather
+// than with a password.
+func (u *AdminUser) IsSSO() bool {
+	return u.OIDCSubject != ""
+}
+
+// HasRole reports whether the user's role is at least as privileged as role.
+func (u *AdminUser) HasRole(role string) bool {
+	return roleRank(u.Role) >= roleRank(role) && roleRank(role) > 0
+}
+
+// Ways an admin session can be signed in
+const (
+	AuthMethodPassword = "password"
+	AuthMethodOIDC     = "oidc"
+	AuthMethodProxy    = "proxy"
+)
+
+// AdminSession is a signed-in admin browser session. The session cookie
+// holds a random token that identifies the session.
+type AdminSession struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	AuthMethod string    `json:"auth_method"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// Login attempt stages and results. An unlock by an owner is recorded
+// like a successful login so it clears earlier failures.
+const (
+	LoginStagePassword  = "password"
+	LoginStageTwoFactor = "two_factor"
+	LoginStageOIDC      = "oidc"
+	LoginStageProxy     = "proxy"
+	// A signed-in admin confirming their password for a sensitive change
+	LoginStageReauth = "reauth"
+
+	LoginResultSuccess  = "success"
+	LoginResultFailure  = "failure"
+	LoginResultUnlocked = "unlocked"
+)
+
+// LoginAttempt is a recorded admin sign-in attempt.
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	IPAddress string    `json:"ip_address"`
+	Stage     string    `json:"stage"`
+	Result    string    `json:"result"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginFailures summarizes recent failed sign-ins for one username or IP
+// address since it last signed in successfully.
+type LoginFailures struct {
+	Value       string
+	Count       int
+	LastFailure time.Time
 }
 
 type APIKey struct {
@@ -16,8 +162,62 @@ type APIKey struct {
 	KeyHash   string     `json:"-"`
 	Name      string     `json:"name"`
 	Enabled   bool       `json:"enabled"`
+	Scopes    []string   `json:"scopes"`
 	CreatedAt time.Time  `json:"created_at"`
 	LastUsed  *time.Time `json:"last_used,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// After rotation the previous secret stays valid until this time
+	PreviousKeyHash      string     `json:"-"`
+	PreviousKeyExpiresAt *time.Time `json:"previous_key_expires_at,omitempty"`
+
+	// Restrictions; empty means unrestricted
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedReferrers []string `json:"allowed_referrers"`
+	AllowedIPs       []string `json:"allowed_ips"`
+	AllowedImageIDs  []int    `json:"allowed_image_ids"`
+
+	// Limits; zero means unlimited
+	RateLimitPerSecond float64 `json:"rate_limit_per_second"`
+	RateLimitBurst     int     `json:"rate_limit_burst"`
+	DailyImageQuota    int     `json:"daily_image_quota"`
+	MonthlyImageQuota  int     `json:"monthly_image_quota"`
+}
+
+func (k *APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now())
+}
+
+// ExpiresWithin reports whether the key has not yet expired but will within d.
+func (k *APIKey) ExpiresWithin(d time.Duration) bool {
+	return k.ExpiresAt != nil && !k.IsExpired() && k.ExpiresAt.Before(time.Now().Add(d))
+}
+
+// InGracePeriod reports whether a rotated-out secret is still accepted.
+func (k *APIKey) InGracePeriod() bool {
+	return k.PreviousKeyExpiresAt != nil && k.PreviousKeyExpiresAt.After(time.Now())
+}
+
+// CanAccessImage reports whether the key may draw from the given image.
+func (k *APIKey) CanAccessImage(imageID int) bool {
+	if len(k.AllowedImageIDs) == 0 {
+		return true
+	}
+	for _, id := range k.AllowedImageIDs {
+		if id == imageID {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
 type APIRequest struct {
@@ -27,6 +227,42 @@ type APIRequest struct {
 	ImageCount int       `json:"image_count"`
 }
 
+// Granularities of the API usage statistics
+const (
+	UsageIntervalHour = "hour"
+	UsageIntervalDay  = "day"
+)
+
+// UsageCounts are API usage totals. Errors are requests answered with a 4xx
+// or 5xx status.
+type UsageCounts struct {
+	Requests     int `json:"requests"`
+	ImagesServed int `json:"images_served"`
+	Errors       int `json:"errors"`
+}
+
+// UsageKey identifies the hour and API key that usage is counted towards.
+// APIKeyID is 0 for requests made without a key.
+type UsageKey struct {
+	Hour     time.Time
+	APIKeyID int
+}
+
+// UsageBucket is the API usage in one hour or day, or over a whole range.
+type UsageBucket struct {
+	Start time.Time `json:"start"`
+	UsageCounts
+	UniqueKeys int `json:"unique_keys"`
+}
+
+// KeyUsage is one API key's usage over a range. APIKeyID is 0 for requests
+// made without a key, and Name is empty if the key has been deleted.
+type KeyUsage struct {
+	APIKeyID int    `json:"api_key_id"`
+	Name     string `json:"name"`
+	UsageCounts
+}
+
 type ImageFile struct {
 	ID       int    `json:"id"`
 	Filename string `json:"filename"`
@@ -34,6 +270,150 @@ type ImageFile struct {
 	MimeType string `json:"mime_type"`
 	Enabled  bool   `json:"enabled"`
 	UploadedAt time.Time `json:"uploaded_at"`
+	// Set while the image is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Empty when the image isn't in a collection
+	Collection string   `json:"collection,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// The image is only served between these times, when set
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty"`
+	ImageMetadata
+	ImageServeCounts
+}
+
+// ImageServeCounts is how often an image was picked by the random images
+// API and how often its file was fetched through the API.
+type ImageServeCounts struct {
+	ServedCount   int        `json:"served_count"`
+	FetchedCount  int        `json:"fetched_count"`
+	LastServedAt  *time.Time `json:"last_served_at,omitempty"`
+	LastFetchedAt *time.Time `json:"last_fetched_at,omitempty"`
+}
+
+// Where an image is in its publishing window
+const (
+	PublishStateLive      = "live"
+	PublishStateScheduled = "scheduled"
+	PublishStateExpired   = "expired"
+)
+
+// PublishState reports whether the image's publishing window has opened
+// and not yet closed at now, regardless of whether it is enabled.
+func (img *ImageFile) PublishState(now time.Time) string {
+	if img.PublishAt != nil && img.PublishAt.After(now) {
+		return PublishStateScheduled
+	}
+	if img.UnpublishAt != nil && !img.UnpublishAt.After(now) {
+		return PublishStateExpired
+	}
+	return PublishStateLive
+}
+
+// IsServed reports whether the image is enabled and inside its publishing
+// window at now.
+func (img *ImageFile) IsServed(now time.Time) bool {
+	return img.Enabled && img.DeletedAt == nil && img.PublishState(now) == PublishStateLive
+}
+
+// ImageMetadata describes an image for display and credit. Every field is
+// optional.
+type ImageMetadata struct {
+	Title       string `json:"title,omitempty"`
+	AltText     string `json:"alt_text,omitempty"`
+	Attribution string `json:"attribution,omitempty"`
+	SourceURL   string `json:"source_url,omitempty"`
+	License     string `json:"license,omitempty"`
+}
+
+// Sort orders for image listings
+const (
+	ImageSortNewest   = "newest"
+	ImageSortOldest   = "oldest"
+	ImageSortName     = "name"
+	ImageSortNameDesc = "name_desc"
+	ImageSortLargest  = "largest"
+	ImageSortSmallest = "smallest"
+	// By how often the random images API picked the image
+	ImageSortMostServed  = "most_served"
+	ImageSortLeastServed = "least_served"
+)
+
+// ImageQuery filters, sorts and pages a listing of the images outside the
+// trash. Zero values don't filter.
+type ImageQuery struct {
+	// Part of the filename or title, matched case-insensitively
+	Search  string
+	Enabled *bool
+	// One of the PublishState values
+	PublishState string
+	// Only images that have never been served or fetched
+	NeverServed bool
+	MimeType    string
+	Tag         string
+	Collection  string
+	// Uploaded at or after UploadedAfter and before UploadedBefore
+	UploadedAfter  time.Time
+	UploadedBefore time.Time
+	// In bytes
+	MinSize int64
+	MaxSize int64
+
+	// One of the ImageSort values, ImageSortNewest by default
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// ImagePage is one page of an ImageQuery.
+type ImagePage struct {
+	Images []*ImageFile
+	// Matching images and their combined size across all pages
+	Total     int
+	TotalSize int64
+}
+
+// Actions that can be applied to many images at once
+const (
+	BulkActionEnable  = "enable"
+	BulkActionDisable = "disable"
+	BulkActionDelete  = "delete"
+	BulkActionTag     = "tag"
+	BulkActionUntag   = "untag"
+	BulkActionMove    = "move"
+)
+
+// BulkImageUpdate describes a change applied to many images in one
+// transaction. Nil and empty fields are left alone.
+type BulkImageUpdate struct {
+	Enabled    *bool
+	Trash      bool
+	AddTags    []string
+	RemoveTags []string
+	// Moves the images to this collection; an empty name takes them out of
+	// their collection
+	Collection *string
+}
+
+// Outcomes of a bulk action for a single image
+const (
+	BulkResultUpdated  = "updated"
+	BulkResultNotFound = "not_found"
+)
+
+// BulkImageResult reports what a bulk action did to one image.
+type BulkImageResult struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+}
+
+// SigningSecret is an HMAC secret used to sign image URLs. Retired secrets
+// keep verifying until ExpiresAt so previously issued URLs stay valid.
+type SigningSecret struct {
+	ID        int        `json:"id"`
+	Secret    string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type Setting struct {
@@ -0,0 +1,281 @@
+// Package oidc implements the parts of OpenID Connect needed to sign users in
+// with the authorization code flow and PKCE: provider discovery, the token
+// exchange and ID token verification.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config identifies this application to an OpenID provider.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string // empty for public clients that rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+}
+
+// metadata is the subset of the provider's discovery document we use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one OpenID provider. Discovery and signing keys are
+// fetched on first use and cached.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// Tokens is the result of a successful code exchange.
+type Tokens struct {
+	IDToken     string
+	AccessToken string
+}
+
+const (
+	requestTimeout = 10 * time.Second
+	// Allowed difference between our clock and the provider's
+	clockSkew = time.Minute
+	// How often unknown key IDs may trigger a refetch of the provider's keys
+	keyRefreshInterval = time.Minute
+)
+
+func NewProvider(config Config) *Provider {
+	config.IssuerURL = strings.TrimRight(config.IssuerURL, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var md metadata
+	if err := p.getJSON(ctx, p.config.IssuerURL+"/.well-known/openid-configuration", "", &md); err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID provider: %w", err)
+	}
+	if strings.TrimRight(md.Issuer, "/") != p.config.IssuerURL {
+		return nil, fmt.Errorf("provider issuer %q does not match configured issuer %q", md.Issuer, p.config.IssuerURL)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("provider discovery document is missing required endpoints")
+	}
+
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the provider URL to send the browser to. state and
+// nonce tie the response to this login attempt and codeVerifier is the PKCE
+// secret that must later be passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (*Tokens, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("token response did not include an ID token")
+	}
+
+	return &Tokens{IDToken: body.IDToken, AccessToken: body.AccessToken}, nil
+}
+
+// VerifyIDToken checks the ID token's signature, issuer, audience, expiry
+// and nonce, and returns its claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.verifyJWT(ctx, md, rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.String("iss") != md.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", claims.String("iss"), md.Issuer)
+	}
+	audiences := claims.Strings("aud")
+	if !contains(audiences, p.config.ClientID) {
+		return nil, errors.New("ID token was not issued for this client")
+	}
+	if len(audiences) > 1 && claims.String("azp") != p.config.ClientID {
+		return nil, errors.New("ID token has an unexpected authorized party")
+	}
+
+	now := time.Now()
+	exp, ok := claims.Time("exp")
+	if !ok || now.After(exp.Add(clockSkew)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if iat, ok := claims.Time("iat"); ok && iat.After(now.Add(clockSkew)) {
+		return nil, errors.New("ID token was issued in the future")
+	}
+	if claims.String("nonce") != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if claims.String("sub") == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+// UserInfo fetches claims from the provider's userinfo endpoint. It returns
+// nil if the provider has none.
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (Claims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if md.UserInfoEndpoint == "" || accessToken == "" {
+		return nil, nil
+	}
+
+	var claims Claims
+	if err := p.getJSON(ctx, md.UserInfoEndpoint, accessToken, &claims); err != nil {
+		return nil, fmt.Errorf("failed to fetch user info: %w", err)
+	}
+	return claims, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// RandomString returns a URL-safe random string for state, nonce and PKCE values.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge derives the S256 code challenge for verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,350 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testClientID = "shufflr"
+
+// testProvider is an OpenID provider serving discovery and signing keys.
+type testProvider struct {
+	*httptest.Server
+	// Issuer in the discovery document; the server URL if empty
+	issuer    string
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	p384Key   *ecdsa.PrivateKey
+	jwks      []map[string]string
+	jwksCalls atomic.Int32
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := &testProvider{rsaKey: rsaKey, ecKey: ecKey, p384Key: p384Key}
+	tp.jwks = []map[string]string{
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		ecJWK("ec", "P-256", &ecKey.PublicKey),
+		ecJWK("p384", "P-384", &p384Key.PublicKey),
+		// Encryption keys must not be used to verify signatures
+		func() map[string]string { k := rsaJWK("enc", &rsaKey.PublicKey); k["use"] = "enc"; return k }(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := tp.issuer
+		if issuer == "" {
+			issuer = tp.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": tp.URL + "/authorize",
+			"token_endpoint":         tp.URL + "/token",
+			"jwks_uri":               tp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		tp.jwksCalls.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": tp.jwks})
+	})
+	tp.Server = httptest.NewServer(mux)
+	t.Cleanup(tp.Close)
+	return tp
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid, crv string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"crv": crv,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a compact JWS of claims signed by key with alg. The kid
+// header is left out when kid is empty.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signingInput := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+
+	hash, err := hashForAlg(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	tp := newTestProvider(t)
+	provider := NewProvider(Config{IssuerURL: tp.URL + "/", ClientID: testClientID})
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   tp.URL,
+			"sub":   "user-1",
+			"aud":   testClientID,
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "nonce-1",
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+	valid := sign(t, "RS256", "rsa", tp.rsaKey, claims(nil))
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr string
+	}{
+		{"RS256", valid, "nonce-1", ""},
+		{"RS384", sign(t, "RS384", "rsa", tp.rsaKey, claims(nil)), "nonce-1", ""},
+		{"RS512", sign(t, "RS512", "rsa", tp.rsaKey, claims(nil)), "nonce-1", ""},
+		{"ES256", sign(t, "ES256", "ec", tp.ecKey, claims(nil)), "nonce-1", ""},
+		{"ES384", sign(t, "ES384", "p384", tp.p384Key, claims(nil)), "nonce-1", ""},
+		{"audience array", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"aud": []string{testClientID}})), "nonce-1", ""},
+		{"several audiences with azp", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"aud": []string{"other", testClientID}, "azp": testClientID})), "nonce-1", ""},
+		{"expired within clock skew", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"exp": now.Add(-clockSkew / 2).Unix()})), "nonce-1", ""},
+
+		{"malformed", "not-a-jwt", "nonce-1", "malformed ID token"},
+		{"malformed header", "!!." + strings.SplitN(valid, ".", 2)[1], "nonce-1", "malformed ID token header"},
+		{"alg none", encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, claims(nil)) + ".", "nonce-1", "unsupported ID token algorithm"},
+		{"HS256", encodeSegment(t, map[string]string{"alg": "HS256", "kid": "rsa"}) + "." + encodeSegment(t, claims(nil)) + ".c2ln", "nonce-1", "unsupported ID token algorithm"},
+		{"tampered payload", strings.Split(valid, ".")[0] + "." + encodeSegment(t, claims(map[string]interface{}{"sub": "admin"})) + "." + strings.Split(valid, ".")[2], "nonce-1", "invalid ID token signature"},
+		{"signed by another key", sign(t, "RS256", "rsa", mustRSAKey(t), claims(nil)), "nonce-1", "invalid ID token signature"},
+		{"truncated EC signature", truncateSignature(sign(t, "ES256", "ec", tp.ecKey, claims(nil))), "nonce-1", "invalid ID token signature"},
+		{"RS alg with EC key", sign(t, "RS256", "ec", tp.rsaKey, claims(nil)), "nonce-1", "algorithm RS256 does not match EC key"},
+		{"ES alg with RSA key", sign(t, "ES256", "rsa", tp.ecKey, claims(nil)), "nonce-1", "algorithm ES256 does not match RSA key"},
+		{"unknown key", sign(t, "RS256", "rotated", tp.rsaKey, claims(nil)), "nonce-1", `unknown signing key "rotated"`},
+		{"encryption key", sign(t, "RS256", "enc", tp.rsaKey, claims(nil)), "nonce-1", `unknown signing key "enc"`},
+		{"no key ID with several keys", sign(t, "RS256", "", tp.rsaKey, claims(nil)), "nonce-1", `unknown signing key ""`},
+		{"wrong issuer", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"iss": "https://evil.test"})), "nonce-1", "ID token issued by"},
+		{"wrong audience", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"aud": "other"})), "nonce-1", "ID token was not issued for this client"},
+		{"several audiences without azp", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"aud": []string{"other", testClientID}})), "nonce-1", "ID token has an unexpected authorized party"},
+		{"expired", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"exp": now.Add(-2 * clockSkew).Unix()})), "nonce-1", "ID token has expired"},
+		{"no expiry", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"exp": nil})), "nonce-1", "ID token has expired"},
+		{"issued in the future", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"iat": now.Add(2 * clockSkew).Unix()})), "nonce-1", "ID token was issued in the future"},
+		{"wrong nonce", valid, "nonce-2", "ID token nonce does not match"},
+		{"missing nonce", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"nonce": nil})), "nonce-1", "ID token nonce does not match"},
+		{"no subject", sign(t, "RS256", "rsa", tp.rsaKey, claims(map[string]interface{}{"sub": nil})), "nonce-1", "ID token has no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.VerifyIDToken(context.Background(), tt.token, tt.nonce)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.String("sub") != "user-1" {
+					t.Errorf("sub = %q, want user-1", got.String("sub"))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Unknown key IDs refetch the key set at most once per refresh interval
+	if calls := tp.jwksCalls.Load(); calls != 1 {
+		t.Errorf("key set fetched %d times, want 1", calls)
+	}
+}
+
+// truncateSignature drops the last byte of a token's signature.
+func truncateSignature(token string) string {
+	i := strings.LastIndex(token, ".")
+	signature, _ := base64.RawURLEncoding.DecodeString(token[i+1:])
+	return token[:i+1] + base64.RawURLEncoding.EncodeToString(signature[:len(signature)-1])
+}
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyJWTWithoutKeyID(t *testing.T) {
+	tp := newTestProvider(t)
+	tp.jwks = tp.jwks[:1]
+	provider := NewProvider(Config{IssuerURL: tp.URL, ClientID: testClientID})
+	md, err := provider.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token without a key ID is accepted when the provider has a single key
+	token := sign(t, "RS256", "", tp.rsaKey, map[string]interface{}{"sub": "user-1"})
+	claims, err := provider.verifyJWT(context.Background(), md, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != "user-1" {
+		t.Errorf("sub = %q, want user-1", claims.String("sub"))
+	}
+}
+
+func TestVerifyJWTRefetchesRotatedKeys(t *testing.T) {
+	tp := newTestProvider(t)
+	provider := NewProvider(Config{IssuerURL: tp.URL, ClientID: testClientID})
+	md, err := provider.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.verifyJWT(context.Background(), md, sign(t, "RS256", "rsa", tp.rsaKey, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates to a new key; once the refresh interval has
+	// passed, its unknown key ID triggers a refetch
+	newKey := mustRSAKey(t)
+	tp.jwks = []map[string]string{rsaJWK("rsa-2", &newKey.PublicKey)}
+	provider.keys.fetchedAt = time.Now().Add(-keyRefreshInterval)
+
+	if _, err := provider.verifyJWT(context.Background(), md, sign(t, "RS256", "rsa-2", newKey, nil)); err != nil {
+		t.Fatalf("token signed by the rotated key: %v", err)
+	}
+	if calls := tp.jwksCalls.Load(); calls != 2 {
+		t.Errorf("key set fetched %d times, want 2", calls)
+	}
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	tp := newTestProvider(t)
+	tp.issuer = "https://evil.test"
+	provider := NewProvider(Config{IssuerURL: tp.URL, ClientID: testClientID})
+	_, err := provider.VerifyIDToken(context.Background(), sign(t, "RS256", "rsa", tp.rsaKey, nil), "")
+	if err == nil || !strings.Contains(err.Error(), "does not match configured issuer") {
+		t.Errorf("error = %v, want issuer mismatch", err)
+	}
+}
+
+func TestClaims(t *testing.T) {
+	var claims Claims
+	if err := json.Unmarshal([]byte(`{
+		"email": "a@example.com",
+		"groups": ["admins", 3, "editors"],
+		"role": "admin",
+		"realm_access": {"roles": ["viewer"]},
+		"a.b": "flat",
+		"a": {"b": "nested"},
+		"exp": 1700000000
+	}`), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"string", claims.String("email"), "a@example.com"},
+		{"missing string", claims.String("name"), ""},
+		{"non-string", claims.String("exp"), ""},
+		{"strings from array", strings.Join(claims.Strings("groups"), ","), "admins,editors"},
+		{"strings from string", strings.Join(claims.Strings("role"), ","), "admin"},
+		{"nested claim", strings.Join(claims.Strings("realm_access.roles"), ","), "viewer"},
+		{"top-level dotted name wins", claims.String("a.b"), "flat"},
+		{"missing nested claim", claims.String("realm_access.missing.deeper"), ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if exp, ok := claims.Time("exp"); !ok || exp.Unix() != 1700000000 {
+		t.Errorf("Time(exp) = %v, %v", exp, ok)
+	}
+	if _, ok := claims.Time("email"); ok {
+		t.Error("Time accepted a string claim")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636 Appendix B
+	got := PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("PKCEChallenge = %s, want %s", got, want)
+	}
+}
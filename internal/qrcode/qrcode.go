@@ -0,0 +1,515 @@
+// Package qrcode renders short strings, such as otpauth:// URIs, as QR codes.
+//
+// It implements the subset of ISO/IEC 18004 needed for that: byte mode,
+// error correction level M and versions 1 through 10 (up to 213 bytes).
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// QRCode is an encoded symbol; Modules[y][x] is true for dark modules.
+type QRCode struct {
+	Size    int
+	Modules [][]bool
+
+	version  int
+	function [][]bool
+}
+
+// versionBlocks lists the data codewords in each error correction block and
+// the error correction codewords per block for level M, versions 1-10.
+var versionBlocks = [...]struct {
+	data []int
+	ecc  int
+}{
+	1:  {[]int{16}, 10},
+	2:  {[]int{28}, 16},
+	3:  {[]int{44}, 26},
+	4:  {[]int{32, 32}, 18},
+	5:  {[]int{43, 43}, 24},
+	6:  {[]int{27, 27, 27, 27}, 16},
+	7:  {[]int{31, 31, 31, 31}, 18},
+	8:  {[]int{38, 38, 39, 39}, 22},
+	9:  {[]int{36, 36, 36, 37, 37}, 22},
+	10: {[]int{43, 43, 43, 43, 44}, 26},
+}
+
+var alignmentPositions = [...][]int{
+	2:  {6, 18},
+	3:  {6, 22},
+	4:  {6, 26},
+	5:  {6, 30},
+	6:  {6, 34},
+	7:  {6, 22, 38},
+	8:  {6, 24, 42},
+	9:  {6, 26, 46},
+	10: {6, 28, 50},
+}
+
+const maxVersion = 10
+
+// ErrTooLong is returned when the data does not fit in the largest supported version.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// Encode builds the smallest QR code that holds data.
+func Encode(data []byte) (*QRCode, error) {
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		if len(data) <= capacity(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := addErrorCorrection(version, encodeData(version, data))
+
+	size := 17 + 4*version
+	q := &QRCode{Size: size, version: version}
+	q.Modules = newGrid(size)
+	q.function = newGrid(size)
+
+	q.drawFunctionPatterns()
+	q.drawCodewords(codewords)
+
+	// Pick the mask with the lowest penalty
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			bestMask, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // XOR again to undo
+	}
+	q.applyMask(bestMask)
+	q.drawFormatBits(bestMask)
+
+	return q, nil
+}
+
+// SVG renders the code as a standalone SVG image with a four-module quiet
+// zone, scaled to pixels wide.
+func (q *QRCode) SVG(pixels int) string {
+	const border = 4
+	dim := q.Size + 2*border
+
+	var path strings.Builder
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.Modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+border, y+border)
+			}
+		}
+	}
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#ffffff"/><path d="%s" fill="#000000"/></svg>`,
+		dim, dim, pixels, pixels, path.String())
+}
+
+func newGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for i := range grid {
+		grid[i] = make([]bool, size)
+	}
+	return grid
+}
+
+func dataCodewords(version int) int {
+	total := 0
+	for _, n := range versionBlocks[version].data {
+		total += n
+	}
+	return total
+}
+
+// capacity is the number of data bytes a version holds in byte mode.
+func capacity(version int) int {
+	header := 4 + 8 // mode indicator and 8-bit length for versions 1-9
+	if version >= 10 {
+		header = 4 + 16
+	}
+	return (dataCodewords(version)*8 - header) / 8
+}
+
+// encodeData produces the data codewords: mode, length, payload, terminator and padding.
+func encodeData(version int, data []byte) []byte {
+	var bits bitBuffer
+	bits.append(0x4, 4) // byte mode
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacityBits := dataCodewords(version) * 8
+	terminator := capacityBits - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	if rem := len(bits) % 8; rem != 0 {
+		bits.append(0, 8-rem)
+	}
+	for pad := 0xEC; len(bits) < capacityBits; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	return bits.bytes()
+}
+
+// addErrorCorrection splits data into blocks, appends Reed-Solomon codewords
+// and interleaves the result.
+func addErrorCorrection(version int, data []byte) []byte {
+	layout := versionBlocks[version]
+	divisor := rsDivisor(layout.ecc)
+
+	var blocks, eccs [][]byte
+	offset, maxLen := 0, 0
+	for _, n := range layout.data {
+		block := data[offset : offset+n]
+		offset += n
+		blocks = append(blocks, block)
+		eccs = append(eccs, rsRemainder(block, divisor))
+		if n > maxLen {
+			maxLen = n
+		}
+	}
+
+	var result []byte
+	for i := 0; i < maxLen; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecc; i++ {
+		for _, ecc := range eccs {
+			result = append(result, ecc[i])
+		}
+	}
+	return result
+}
+
+func (q *QRCode) setFunction(x, y int, dark bool) {
+	q.Modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *QRCode) drawFunctionPatterns() {
+	// Timing patterns
+	for i := 0; i < q.Size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	q.drawFinder(3, 3)
+	q.drawFinder(q.Size-4, 3)
+	q.drawFinder(3, q.Size-4)
+
+	// Alignment patterns, except where they would overlap the finders
+	if q.version >= 2 {
+		positions := alignmentPositions[q.version]
+		last := len(positions) - 1
+		for i, y := range positions {
+			for j, x := range positions {
+				if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+					continue
+				}
+				q.drawAlignment(x, y)
+			}
+		}
+	}
+
+	// Reserve the format areas; the real bits are drawn after masking
+	q.drawFormatBits(0)
+	q.drawVersion()
+}
+
+func (q *QRCode) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= q.Size || y >= q.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			q.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *QRCode) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (q *QRCode) drawFormatBits(mask int) {
+	// Level M has format bits 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(bits, i))
+	}
+	q.setFunction(8, 7, bit(bits, 6))
+	q.setFunction(8, 8, bit(bits, 7))
+	q.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, bit(bits, i))
+	}
+	q.setFunction(8, q.Size-8, true) // dark module
+}
+
+func (q *QRCode) drawVersion() {
+	if q.version < 7 {
+		return
+	}
+	rem := q.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := q.version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		a, b := q.Size-11+i%3, i/3
+		q.setFunction(a, b, bit(bits, i))
+		q.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords places data in the zigzag pattern, two columns at a time
+// from the bottom right, skipping function modules.
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.Modules[y][x] = bit(int(data[i>>3]), 7-(i&7))
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				q.Modules[y][x] = !q.Modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol using the four rules from the specification.
+func (q *QRCode) penalty() int {
+	result := 0
+	get := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.Modules[x][y]
+		}
+		return q.Modules[y][x]
+	}
+
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < q.Size; y++ {
+			// Runs of five or more modules of the same colour
+			run := 1
+			for x := 1; x < q.Size; x++ {
+				if get(x, y, vertical) == get(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+			if run >= 5 {
+				result += 3 + run - 5
+			}
+
+			// 1:1:3:1:1 patterns with four light modules on one side
+			for x := 0; x+7 <= q.Size; x++ {
+				match := true
+				for k, dark := range finderLike {
+					if get(x+k, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				if q.lightRun(x-4, x, y, vertical) || q.lightRun(x+7, x+11, y, vertical) {
+					result += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same colour
+	dark := 0
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.Modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.Modules[y][x]
+				if c == q.Modules[y][x-1] && c == q.Modules[y-1][x] && c == q.Modules[y-1][x-1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := q.Size * q.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+
+	return result
+}
+
+// lightRun reports whether modules from..to (exclusive) on line y are all
+// light, treating modules outside the symbol as light.
+func (q *QRCode) lightRun(from, to, y int, vertical bool) bool {
+	for x := from; x < to; x++ {
+		if x < 0 || x >= q.Size {
+			continue
+		}
+		if (vertical && q.Modules[x][y]) || (!vertical && q.Modules[y][x]) {
+			return false
+		}
+	}
+	return true
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, set := range b {
+		if set {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func bit(value, i int) bool {
+	return (value>>i)&1 == 1
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
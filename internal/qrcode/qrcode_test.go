@@ -0,0 +1,398 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The decoder below reads symbols back following ISO/IEC 18004 directly,
+// sharing no code with the encoder, so that encoding mistakes show up as
+// failed round trips.
+
+// specBlocks is the level M block structure from the standard's table 9:
+// groups of (block count, data codewords per block), and the error
+// correction codewords per block.
+var specBlocks = map[int]struct {
+	groups [][2]int
+	ecc    int
+}{
+	1:  {[][2]int{{1, 16}}, 10},
+	2:  {[][2]int{{1, 28}}, 16},
+	3:  {[][2]int{{1, 44}}, 26},
+	4:  {[][2]int{{2, 32}}, 18},
+	5:  {[][2]int{{2, 43}}, 24},
+	6:  {[][2]int{{4, 27}}, 16},
+	7:  {[][2]int{{4, 31}}, 18},
+	8:  {[][2]int{{2, 38}, {2, 39}}, 22},
+	9:  {[][2]int{{3, 36}, {2, 37}}, 22},
+	10: {[][2]int{{4, 43}, {1, 44}}, 26},
+}
+
+// specAlignment is the alignment pattern centre table from annex E.
+var specAlignment = map[int][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+type symbol struct {
+	q       *QRCode
+	version int
+}
+
+func (s symbol) dark(x, y int) bool {
+	return s.q.Modules[y][x]
+}
+
+// isFunction reports whether a module belongs to a function pattern or the
+// format and version information rather than to the data.
+func (s symbol) isFunction(x, y int) bool {
+	size := s.q.Size
+	switch {
+	case x < 9 && y < 9, x >= size-8 && y < 9, x < 9 && y >= size-8:
+		return true // finders, separators and format information
+	case x == 6 || y == 6:
+		return true // timing patterns
+	case s.version >= 7 && ((x >= size-11 && x < size-8 && y < 6) || (y >= size-11 && y < size-8 && x < 6)):
+		return true // version information
+	}
+	centres := specAlignment[s.version]
+	for i, cy := range centres {
+		for j, cx := range centres {
+			last := len(centres) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			if x >= cx-2 && x <= cx+2 && y >= cy-2 && y <= cy+2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatBits reads both copies of the 15-bit format information, with bit 0
+// in the least significant position.
+func (s symbol) formatBits() (int, int) {
+	size := s.q.Size
+	var first, second int
+	// Around the top left finder: down column 8, then left along row 8
+	firstPositions := [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}}
+	for i, p := range firstPositions {
+		if s.dark(p[0], p[1]) {
+			first |= 1 << i
+		}
+	}
+	// Split between the top right and bottom left finders
+	for i := 0; i < 8; i++ {
+		if s.dark(size-1-i, 8) {
+			second |= 1 << i
+		}
+	}
+	for i := 8; i < 15; i++ {
+		if s.dark(8, size-15+i) {
+			second |= 1 << i
+		}
+	}
+	return first, second
+}
+
+// bchFormat computes the 15-bit format word for 5 data bits.
+func bchFormat(data int) int {
+	value := data << 10
+	for i := 14; i >= 10; i-- {
+		if value&(1<<i) != 0 {
+			value ^= 0x537 << (i - 10)
+		}
+	}
+	return (data<<10 | value) ^ 0x5412
+}
+
+var specMasks = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (y/2+x/3)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// gfMul multiplies in GF(256) with the QR code polynomial x^8+x^4+x^3+x^2+1.
+func gfMul(a, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1d
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// syndromesZero reports whether a Reed-Solomon block with ecc correction
+// codewords is a valid codeword, i.e. divisible by the generator whose roots
+// are α^0 … α^(ecc-1).
+func syndromesZero(block []byte, ecc int) bool {
+	alpha := byte(1)
+	for i := 0; i < ecc; i++ {
+		var value byte
+		for _, c := range block {
+			value = gfMul(value, alpha) ^ c
+		}
+		if value != 0 {
+			return false
+		}
+		alpha = gfMul(alpha, 2)
+	}
+	return true
+}
+
+// decode reads the payload of a level M, byte mode symbol.
+func decode(q *QRCode) ([]byte, error) {
+	if (q.Size-17)%4 != 0 || len(q.Modules) != q.Size {
+		return nil, fmt.Errorf("invalid size %d", q.Size)
+	}
+	s := symbol{q: q, version: (q.Size - 17) / 4}
+	layout, ok := specBlocks[s.version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported version %d", s.version)
+	}
+
+	if !s.dark(8, q.Size-8) {
+		return nil, errors.New("dark module missing")
+	}
+	for i := 8; i < q.Size-8; i++ {
+		if s.dark(i, 6) != (i%2 == 0) || s.dark(6, i) != (i%2 == 0) {
+			return nil, fmt.Errorf("timing pattern broken at %d", i)
+		}
+	}
+
+	if s.version >= 7 {
+		var bits, transposed int
+		for i := 0; i < 18; i++ {
+			if s.dark(q.Size-11+i%3, i/3) {
+				bits |= 1 << i
+			}
+			if s.dark(i/3, q.Size-11+i%3) {
+				transposed |= 1 << i
+			}
+		}
+		if bits>>12 != s.version || transposed != bits {
+			return nil, fmt.Errorf("version information %#x does not match version %d", bits, s.version)
+		}
+	}
+
+	first, second := s.formatBits()
+	if first != second {
+		return nil, fmt.Errorf("format copies differ: %#x and %#x", first, second)
+	}
+	mask := -1
+	for data := 0; data < 32; data++ {
+		if bchFormat(data) == first {
+			if data>>3 != 0 {
+				return nil, fmt.Errorf("error correction level bits %02b, want 00 (M)", data>>3)
+			}
+			mask = data & 7
+		}
+	}
+	if mask < 0 {
+		return nil, fmt.Errorf("invalid format information %#x", first)
+	}
+
+	// Read the zigzag, two columns at a time from the bottom right
+	var bits []bool
+	upward := true
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for i := 0; i < q.Size; i++ {
+			y := i
+			if upward {
+				y = q.Size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if !s.isFunction(x, y) {
+					bits = append(bits, s.dark(x, y) != specMasks[mask](x, y))
+				}
+			}
+		}
+		upward = !upward
+	}
+
+	var sizes []int
+	totalData := 0
+	for _, group := range layout.groups {
+		for i := 0; i < group[0]; i++ {
+			sizes = append(sizes, group[1])
+			totalData += group[1]
+		}
+	}
+	total := totalData + len(sizes)*layout.ecc
+	if len(bits) < total*8 {
+		return nil, fmt.Errorf("only %d data modules for %d codewords", len(bits), total)
+	}
+	codewords := make([]byte, total)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				codewords[i] |= 0x80 >> j
+			}
+		}
+	}
+
+	// De-interleave the data and error correction codewords
+	blocks := make([][]byte, len(sizes))
+	next := 0
+	for i := 0; i < sizes[len(sizes)-1]; i++ {
+		for b, n := range sizes {
+			if i < n {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+	for i := 0; i < layout.ecc; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+	for b, block := range blocks {
+		if !syndromesZero(block, layout.ecc) {
+			return nil, fmt.Errorf("block %d fails the Reed-Solomon check", b)
+		}
+	}
+
+	// Parse the byte mode segment
+	reader := bitReader{data: data}
+	if mode := reader.read(4); mode != 0x4 {
+		return nil, fmt.Errorf("mode %04b, want byte mode", mode)
+	}
+	lengthBits := 8
+	if s.version >= 10 {
+		lengthBits = 16
+	}
+	length := reader.read(lengthBits)
+	if reader.pos+length*8 > len(data)*8 {
+		return nil, fmt.Errorf("length %d exceeds the symbol", length)
+	}
+	payload := make([]byte, length)
+	for i := range payload {
+		payload[i] = byte(reader.read(8))
+	}
+	return payload, nil
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) int {
+	value := 0
+	for i := 0; i < n; i++ {
+		value <<= 1
+		if r.data[r.pos/8]&(0x80>>(r.pos%8)) != 0 {
+			value |= 1
+		}
+		r.pos++
+	}
+	return value
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		version int
+	}{
+		{"empty", []byte{}, 1},
+		{"single byte", []byte("A"), 1},
+		{"version 1 capacity", []byte(strings.Repeat("x", 14)), 1},
+		{"version 2", []byte(strings.Repeat("x", 15)), 2},
+		{"otpauth URI", []byte("otpauth://totp/Shufflr:admin?algorithm=SHA1&digits=6&issuer=Shufflr&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"), 7},
+		{"version 6 capacity", []byte(strings.Repeat("s", 106)), 6},
+		{"version 7 with version information", []byte(strings.Repeat("s", 107)), 7},
+		{"binary", binary[:150], 8},
+		{"version 9 capacity", []byte(strings.Repeat("n", 180)), 9},
+		{"version 10 with 16-bit length", []byte(strings.Repeat("t", 181)), 10},
+		{"maximum length", binary[:213], 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := Encode(tt.data)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if want := 17 + 4*tt.version; q.Size != want {
+				t.Errorf("size %d, want %d (version %d)", q.Size, want, tt.version)
+			}
+			got, err := decode(q)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("decoded %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+// TestDecodeDetectsCorruption makes sure the round trip test would notice a
+// wrong module.
+func TestDecodeDetectsCorruption(t *testing.T) {
+	q, err := Encode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A data module in the bottom right corner
+	q.Modules[q.Size-1][q.Size-1] = !q.Modules[q.Size-1][q.Size-1]
+	if got, err := decode(q); err == nil && string(got) == "hello" {
+		t.Error("corrupted symbol decoded without error")
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(make([]byte, 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode of 214 bytes returned %v, want ErrTooLong", err)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	q, err := Encode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := q.SVG(200)
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 29 29" width="200" height="200"`,
+		// The top left module of the finder pattern, offset by the quiet zone
+		`M4,4h1v1h-1z`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG missing %q", want)
+		}
+	}
+	if dark := strings.Count(svg, "h1v1h-1z"); dark == 0 || dark >= q.Size*q.Size {
+		t.Errorf("SVG has %d dark modules", dark)
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory token bucket limiter keyed by API key ID.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[int]*bucket
+}
+
+type bucket struct {
+	tokens   float64
+	lastFill time.Time
+}
+
+// Result describes the outcome of a single Allow call.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[int]*bucket),
+	}
+}
+
+// Allow takes one token from the bucket for id, refilling at rate tokens per
+// second up to burst. A non-positive rate means the key is unlimited.
+func (l *Limiter) Allow(id int, rate float64, burst int) Result {
+	if rate <= 0 {
+		return Result{Allowed: true}
+	}
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(burst), lastFill: now}
+		l.buckets[id] = b
+	}
+
+	// Refill based on elapsed time
+	elapsed := now.Sub(b.lastFill).Seconds()
+	b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	b.lastFill = now
+
+	result := Result{Limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	result.Remaining = int(b.tokens)
+	result.ResetAfter = time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second))
+
+	return result
+}
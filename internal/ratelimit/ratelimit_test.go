@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// advance makes the bucket for id behave as if d had passed since its last
+// refill.
+func advance(l *Limiter, id int, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[id]; ok {
+		b.lastFill = b.lastFill.Add(-d)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	type step struct {
+		elapsed       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{"burst then denied", 1, 3, []step{
+			{0, true, 2}, {0, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+		{"refills at rate", 2, 2, []step{
+			{0, true, 1}, {0, true, 0}, {0, false, 0},
+			{500 * time.Millisecond, true, 0},
+			{250 * time.Millisecond, false, 0},
+			{250 * time.Millisecond, true, 0},
+		}},
+		{"refill capped at burst", 1, 3, []step{
+			{0, true, 2}, {0, true, 1},
+			{time.Hour, true, 2}, {0, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+		{"fractional rate", 0.5, 1, []step{
+			{0, true, 0}, {time.Second, false, 0}, {time.Second, true, 0},
+		}},
+		{"burst defaults to the rate rounded up", 2.5, 0, []step{
+			{0, true, 2}, {0, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+		{"burst defaults to at least one", 0.1, 0, []step{
+			{0, true, 0}, {0, false, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New()
+			for i, s := range tt.steps {
+				advance(l, 1, s.elapsed)
+				got := l.Allow(1, tt.rate, tt.burst)
+				if got.Allowed != s.wantAllowed || got.Remaining != s.wantRemaining {
+					t.Fatalf("step %d: allowed %v, remaining %d; want %v, %d", i, got.Allowed, got.Remaining, s.wantAllowed, s.wantRemaining)
+				}
+				if got.Allowed && got.RetryAfter != 0 {
+					t.Errorf("step %d: RetryAfter %v on an allowed request", i, got.RetryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l := New()
+	for i := 0; i < 100; i++ {
+		if got := l.Allow(1, 0, 5); !got.Allowed || got.Limit != 0 {
+			t.Fatalf("request %d: %+v, want allowed without a limit", i, got)
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Error("unlimited keys should not get a bucket")
+	}
+}
+
+func TestAllowResult(t *testing.T) {
+	l := New()
+	l.Allow(1, 2, 4)
+	got := l.Allow(1, 2, 4)
+	if got.Limit != 4 {
+		t.Errorf("Limit = %d, want 4", got.Limit)
+	}
+	// Two tokens are missing, refilling at two per second
+	if got.ResetAfter < 990*time.Millisecond || got.ResetAfter > time.Second {
+		t.Errorf("ResetAfter = %v, want about 1s", got.ResetAfter)
+	}
+
+	l.Allow(1, 2, 4)
+	l.Allow(1, 2, 4)
+	denied := l.Allow(1, 2, 4)
+	if denied.Allowed {
+		t.Fatal("expected the bucket to be empty")
+	}
+	// One token refills in half a second
+	if denied.RetryAfter < 490*time.Millisecond || denied.RetryAfter > 500*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want about 500ms", denied.RetryAfter)
+	}
+}
+
+func TestAllowKeysAreIndependent(t *testing.T) {
+	l := New()
+	if !l.Allow(1, 1, 1).Allowed {
+		t.Fatal("first request for key 1 denied")
+	}
+	if l.Allow(1, 1, 1).Allowed {
+		t.Fatal("second request for key 1 allowed")
+	}
+	if !l.Allow(2, 1, 1).Allowed {
+		t.Error("key 2 limited by key 1's requests")
+	}
+}
@@ -0,0 +1,130 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"shufflr/internal/models"
+	"shufflr/internal/storage"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Signer issues and verifies HMAC-signed, expiring image URLs. Secrets are
+// cached in memory so verification needs no database access.
+type Signer struct {
+	db      *storage.DB
+	mu      sync.RWMutex
+	secrets []*models.SigningSecret
+}
+
+func NewSigner(db *storage.DB) (*Signer, error) {
+	s := &Signer{db: db}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	// Create the first secret on a fresh install
+	if len(s.secrets) == 0 {
+		if _, err := s.createSecret(); err != nil {
+			return nil, err
+		}
+		if err := s.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// SignImageURL returns the image URL for filename with exp and sig query
+// parameters that make it valid until now+ttl.
+func (s *Signer) SignImageURL(filename string, ttl time.Duration) string {
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	s.mu.RLock()
+	current := s.secrets[0]
+	s.mu.RUnlock()
+
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("sig", sign(current.Secret, filename, exp))
+	return fmt.Sprintf("/api/images/%s?%s", filename, query.Encode())
+}
+
+// Verify checks a signature for filename against every active secret and
+// returns the expiry time if the URL is valid.
+func (s *Signer) Verify(filename, exp, sig string) (time.Time, bool) {
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expiresAt := time.Unix(expUnix, 0)
+	if !expiresAt.After(time.Now()) {
+		return time.Time{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, secret := range s.secrets {
+		if secret.ExpiresAt != nil && !secret.ExpiresAt.After(time.Now()) {
+			continue
+		}
+		if hmac.Equal([]byte(sign(secret.Secret, filename, exp)), []byte(sig)) {
+			return expiresAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Rotate makes a new secret current. Older secrets keep verifying for
+// overlap so URLs already handed out remain valid until they expire.
+func (s *Signer) Rotate(overlap time.Duration) error {
+	secret, err := s.createSecret()
+	if err != nil {
+		return err
+	}
+	if err := s.db.ExpireSigningSecrets(secret.ID, time.Now().Add(overlap)); err != nil {
+		return err
+	}
+	return s.reload()
+}
+
+// Secrets returns the currently active secrets, newest first.
+func (s *Signer) Secrets() []*models.SigningSecret {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*models.SigningSecret(nil), s.secrets...)
+}
+
+func (s *Signer) createSecret() (*models.SigningSecret, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate signing secret: %w", err)
+	}
+	return s.db.CreateSigningSecret(hex.EncodeToString(secretBytes))
+}
+
+func (s *Signer) reload() error {
+	secrets, err := s.db.GetActiveSigningSecrets()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.secrets = secrets
+	s.mu.Unlock()
+	return nil
+}
+
+func sign(secret, filename, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(filename + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
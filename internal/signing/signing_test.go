@@ -0,0 +1,135 @@
+package signing
+
+import (
+	"net/url"
+	"path/filepath"
+	"shufflr/internal/storage"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) (*Signer, *storage.DB) {
+	t.Helper()
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s, err := NewSigner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+// signedParams signs filename and returns the exp and sig query parameters.
+func signedParams(t *testing.T, s *Signer, filename string, ttl time.Duration) (string, string) {
+	t.Helper()
+	u, err := url.Parse(s.SignImageURL(filename, ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/api/images/"+filename {
+		t.Fatalf("signed URL path = %s", u.Path)
+	}
+	return u.Query().Get("exp"), u.Query().Get("sig")
+}
+
+func TestVerify(t *testing.T) {
+	s, _ := newTestSigner(t)
+	exp, sig := signedParams(t, s, "a.png", time.Hour)
+	secret := s.Secrets()[0].Secret
+
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	later, _ := strconv.ParseInt(exp, 10, 64)
+	extended := strconv.FormatInt(later+3600, 10)
+
+	tests := []struct {
+		name     string
+		filename string
+		exp      string
+		sig      string
+		want     bool
+	}{
+		{"valid", "a.png", exp, sig, true},
+		{"other file", "b.png", exp, sig, false},
+		{"extended expiry", "a.png", extended, sig, false},
+		{"expired", "a.png", past, sign(secret, "a.png", past), false},
+		{"expires now", "a.png", strconv.FormatInt(time.Now().Unix(), 10), sign(secret, "a.png", strconv.FormatInt(time.Now().Unix(), 10)), false},
+		{"non-numeric expiry", "a.png", "tomorrow", sign(secret, "a.png", "tomorrow"), false},
+		{"missing signature", "a.png", exp, "", false},
+		{"wrong signature", "a.png", exp, sign("other-secret", "a.png", exp), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresAt, ok := s.Verify(tt.filename, tt.exp, tt.sig)
+			if ok != tt.want {
+				t.Fatalf("Verify = %v, want %v", ok, tt.want)
+			}
+			if ok && strconv.FormatInt(expiresAt.Unix(), 10) != tt.exp {
+				t.Errorf("expiry = %d, want %s", expiresAt.Unix(), tt.exp)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		name string
+		// Overlap passed to Rotate, and whether the old secret's overlap
+		// has run out by the time the URL is checked
+		overlap     time.Duration
+		overlapOver bool
+		wantOld     bool
+	}{
+		{"within overlap", time.Hour, false, true},
+		{"after overlap", time.Hour, true, false},
+		{"without overlap", 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSigner(t)
+			oldExp, oldSig := signedParams(t, s, "a.png", time.Hour)
+
+			if err := s.Rotate(tt.overlap); err != nil {
+				t.Fatal(err)
+			}
+			if tt.overlapOver {
+				expired := time.Now().Add(-time.Second)
+				for _, secret := range s.Secrets()[1:] {
+					secret.ExpiresAt = &expired
+				}
+			}
+
+			if _, ok := s.Verify("a.png", oldExp, oldSig); ok != tt.wantOld {
+				t.Errorf("URL signed before rotation valid = %v, want %v", ok, tt.wantOld)
+			}
+
+			// New URLs are signed with the new secret
+			newExp, newSig := signedParams(t, s, "a.png", time.Hour)
+			if newSig == oldSig && newExp == oldExp {
+				t.Error("URL signed after rotation is unchanged")
+			}
+			if _, ok := s.Verify("a.png", newExp, newSig); !ok {
+				t.Error("URL signed after rotation rejected")
+			}
+		})
+	}
+}
+
+func TestSecretsSurviveRestart(t *testing.T) {
+	s, db := newTestSigner(t)
+	exp, sig := signedParams(t, s, "a.png", time.Hour)
+
+	restarted, err := NewSigner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.Secrets()) != 1 {
+		t.Errorf("%d secrets after restart, want 1", len(restarted.Secrets()))
+	}
+	if _, ok := restarted.Verify("a.png", exp, sig); !ok {
+		t.Error("URL signed before restart rejected")
+	}
+}
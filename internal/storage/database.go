@@ -6,7 +6,11 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"shufflr/internal/metrics"
 	"shufflr/internal/models"
+	"shufflr/internal/totp"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -14,7 +18,45 @@ import (
 )
 
 type DB struct {
-	conn *sql.DB
+	conn timedConn
+}
+
+var queryDuration = metrics.NewHistogram("shufflr_db_query_duration_seconds",
+	"Time taken to run database statements outside transactions, by statement type.",
+	[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}, "operation")
+
+// timedConn records how long statements run on the connection pool take.
+// Statements inside transactions aren't timed.
+type timedConn struct {
+	*sql.DB
+}
+
+func (c timedConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return c.DB.Exec(query, args...)
+}
+
+func (c timedConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return c.DB.Query(query, args...)
+}
+
+func (c timedConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return c.DB.QueryRow(query, args...)
+}
+
+// observeQuery records a statement's duration under its first keyword, such
+// as select or insert.
+func observeQuery(query string, start time.Time) {
+	operation := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		switch keyword := strings.ToLower(fields[0]); keyword {
+		case "select", "insert", "update", "delete", "create", "alter", "pragma":
+			operation = keyword
+		}
+	}
+	queryDuration.Observe(time.Since(start).Seconds(), operation)
 }
 
 func NewDB(dbPath string) (*DB, error) {
@@ -23,7 +65,7 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: timedConn{conn}}
 	if err := db.migrate(); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
@@ -41,6 +83,13 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) migrate() error {
+	// Usage statistics used to be read from the api_requests log, which is
+	// rolled up once when the usage tables are first created
+	hadUsageTables, err := db.tableExists("usage_hourly")
+	if err != nil {
+		return err
+	}
+
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS admin_users (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -76,8 +125,69 @@ func (db *DB) migrate() error {
 			key TEXT UNIQUE NOT NULL,
 			value TEXT NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS signing_secrets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			secret TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES admin_users (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS admin_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT UNIQUE NOT NULL,
+			user_id INTEGER NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES admin_users (id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_sessions_user_id ON admin_sessions(user_id)`,
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			ip_address TEXT NOT NULL,
+			stage TEXT NOT NULL,
+			result TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON login_attempts(username)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip_address ON login_attempts(ip_address)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_created_at ON login_attempts(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_recovery_codes_user_id ON admin_recovery_codes(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_api_requests_key_id ON api_requests(api_key_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_api_requests_timestamp ON api_requests(timestamp)`,
+		`CREATE TABLE IF NOT EXISTS image_tags (
+			image_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (image_id, tag),
+			FOREIGN KEY (image_id) REFERENCES image_files (id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_tags_tag ON image_tags(tag)`,
+		// API usage per API key, rolled up by hour and by day. api_key_id is 0
+		// for requests made without a key.
+		`CREATE TABLE IF NOT EXISTS usage_hourly (
+			bucket DATETIME NOT NULL,
+			api_key_id INTEGER NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			images_served INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (bucket, api_key_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS usage_daily (
+			bucket DATETIME NOT NULL,
+			api_key_id INTEGER NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			images_served INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (bucket, api_key_id)
+		)`,
 	}
 
 	for _, query := range queries {
@@ -86,24 +196,116 @@ func (db *DB) migrate() error {
 		}
 	}
 
-	// Add enabled column to existing image_files table if it doesn't exist
-	if err := db.addEnabledColumnIfNotExists(); err != nil {
-		return fmt.Errorf("failed to add enabled column: %w", err)
+	// Add columns introduced after the initial schema to existing tables
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+	}{
+		{"image_files", "enabled", "BOOLEAN DEFAULT 1"},
+		// Accounts created before roles existed keep full access
+		{"admin_users", "role", "TEXT NOT NULL DEFAULT '" + models.RoleOwner + "'"},
+		{"admin_users", "enabled", "BOOLEAN NOT NULL DEFAULT 1"},
+		{"admin_users", "session_version", "INTEGER NOT NULL DEFAULT 0"},
+		{"admin_users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"admin_users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"admin_users", "totp_last_counter", "INTEGER NOT NULL DEFAULT 0"},
+		{"admin_users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+		{"admin_users", "proxy_auth", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"admin_sessions", "auth_method", "TEXT NOT NULL DEFAULT '" + models.AuthMethodPassword + "'"},
+		{"api_keys", "scopes", "TEXT NOT NULL DEFAULT '" + models.ScopeImagesRead + "'"},
+		{"api_keys", "rate_limit_per_second", "REAL NOT NULL DEFAULT 0"},
+		{"api_keys", "rate_limit_burst", "INTEGER NOT NULL DEFAULT 0"},
+		{"api_keys", "daily_image_quota", "INTEGER NOT NULL DEFAULT 0"},
+		{"api_keys", "monthly_image_quota", "INTEGER NOT NULL DEFAULT 0"},
+		{"api_keys", "expires_at", "DATETIME"},
+		{"api_keys", "previous_key_hash", "TEXT"},
+		{"api_keys", "previous_key_expires_at", "DATETIME"},
+		{"api_keys", "allowed_origins", "TEXT NOT NULL DEFAULT ''"},
+		{"api_keys", "allowed_referrers", "TEXT NOT NULL DEFAULT ''"},
+		{"api_keys", "allowed_ips", "TEXT NOT NULL DEFAULT ''"},
+		{"api_keys", "allowed_image_ids", "TEXT NOT NULL DEFAULT ''"},
+		// Set while the image is in the trash
+		{"image_files", "deleted_at", "DATETIME"},
+		{"image_files", "collection", "TEXT NOT NULL DEFAULT ''"},
+		{"image_files", "title", "TEXT NOT NULL DEFAULT ''"},
+		{"image_files", "alt_text", "TEXT NOT NULL DEFAULT ''"},
+		{"image_files", "attribution", "TEXT NOT NULL DEFAULT ''"},
+		{"image_files", "source_url", "TEXT NOT NULL DEFAULT ''"},
+		{"image_files", "license", "TEXT NOT NULL DEFAULT ''"},
+		{"image_files", "publish_at", "DATETIME"},
+		{"image_files", "unpublish_at", "DATETIME"},
+		{"image_files", "served_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"image_files", "fetched_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"image_files", "last_served_at", "DATETIME"},
+		{"image_files", "last_fetched_at", "DATETIME"},
 	}
 
+	if !hadUsageTables {
+		if err := db.backfillUsage(); err != nil {
+			return err
+		}
+	}
+
+	for _, col := range columns {
+		if err := db.addColumnIfNotExists(col.table, col.name, col.definition); err != nil {
+			return fmt.Errorf("failed to add %s column: %w", col.name, err)
+		}
+	}
+
+	// Indexes on added columns
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_previous_key_hash ON api_keys(previous_key_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_users_oidc_subject ON admin_users(oidc_subject)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_files_deleted_at ON image_files(deleted_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_files_collection ON image_files(collection)`,
+	}
+
+	for _, query := range indexes {
+		if _, err := db.conn.Exec(query); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) tableExists(table string) (bool, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for %s table: %w", table, err)
+	}
+	return count > 0, nil
+}
+
+// backfillUsage rolls the api_requests log up into the usage tables. The
+// log only holds successful random image requests made with a key.
+func (db *DB) backfillUsage() error {
+	for table, bucket := range map[string]string{
+		"usage_hourly": "%Y-%m-%d %H:00:00",
+		"usage_daily":  "%Y-%m-%d 00:00:00",
+	} {
+		query := fmt.Sprintf(`INSERT INTO %s (bucket, api_key_id, requests, images_served)
+			SELECT strftime('%s', timestamp), api_key_id, COUNT(*), SUM(image_count)
+			FROM api_requests GROUP BY 1, 2`, table, bucket)
+		if _, err := db.conn.Exec(query); err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", table, err)
+		}
+	}
 	return nil
 }
 
-func (db *DB) addEnabledColumnIfNotExists() error {
-	// Check if enabled column exists
-	query := `PRAGMA table_info(image_files)`
+func (db *DB) addColumnIfNotExists(table, column, definition string) error {
+	// Check if column exists
+	query := fmt.Sprintf(`PRAGMA table_info(%s)`, table)
 	rows, err := db.conn.Query(query)
 	if err != nil {
 		return fmt.Errorf("failed to get table info: %w", err)
 	}
 	defer rows.Close()
 
-	hasEnabledColumn := false
+	hasColumn := false
 	for rows.Next() {
 		var cid int
 		var name, dataType string
@@ -115,17 +317,18 @@ func (db *DB) addEnabledColumnIfNotExists() error {
 			return fmt.Errorf("failed to scan column info: %w", err)
 		}
 		
-		if name == "enabled" {
-			hasEnabledColumn = true
+		if name == column {
+			hasColumn = true
 			break
 		}
 	}
+	rows.Close()
 
-	// Add enabled column if it doesn't exist
-	if !hasEnabledColumn {
-		alterQuery := `ALTER TABLE image_files ADD COLUMN enabled BOOLEAN DEFAULT 1`
+	// Add column if it doesn't exist
+	if !hasColumn {
+		alterQuery := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)
 		if _, err := db.conn.Exec(alterQuery); err != nil {
-			return fmt.Errorf("failed to add enabled column: %w", err)
+			return fmt.Errorf("failed to add column: %w", err)
 		}
 	}
 
@@ -133,14 +336,26 @@ func (db *DB) addEnabledColumnIfNotExists() error {
 }
 
 // Admin User methods
-func (db *DB) CreateAdminUser(username, password string) (*models.AdminUser, error) {
+const adminUserColumns = `id, username, password_hash, role, enabled, session_version,
+	totp_secret, totp_enabled, totp_last_counter, oidc_subject, proxy_auth, created_at`
+
+func scanAdminUser(row rowScanner) (*models.AdminUser, error) {
+	var user models.AdminUser
+	if err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Enabled, &user.SessionVersion,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastCounter, &user.OIDCSubject, &user.ProxyAuth, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (db *DB) CreateAdminUser(username, password, role string) (*models.AdminUser, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	query := `INSERT INTO admin_users (username, password_hash) VALUES (?, ?)`
-	result, err := db.conn.Exec(query, username, string(hashedPassword))
+	query := `INSERT INTO admin_users (username, password_hash, role) VALUES (?, ?, ?)`
+	result, err := db.conn.Exec(query, username, string(hashedPassword), role)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create admin user: %w", err)
 	}
@@ -154,16 +369,15 @@ func (db *DB) CreateAdminUser(username, password string) (*models.AdminUser, err
 		ID:           int(id),
 		Username:     username,
 		PasswordHash: string(hashedPassword),
+		Role:         role,
+		Enabled:      true,
 		CreatedAt:    time.Now(),
 	}, nil
 }
 
 func (db *DB) GetAdminUserByUsername(username string) (*models.AdminUser, error) {
-	query := `SELECT id, username, password_hash, created_at FROM admin_users WHERE username = ?`
-	row := db.conn.QueryRow(query, username)
-
-	var user models.AdminUser
-	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt)
+	query := `SELECT ` + adminUserColumns + ` FROM admin_users WHERE username = ?`
+	user, err := scanAdminUser(db.conn.QueryRow(query, username))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -171,260 +385,1680 @@ func (db *DB) GetAdminUserByUsername(username string) (*models.AdminUser, error)
 		return nil, fmt.Errorf("failed to get admin user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func (db *DB) HasAdminUsers() (bool, error) {
-	query := `SELECT COUNT(*) FROM admin_users`
-	var count int
-	err := db.conn.QueryRow(query).Scan(&count)
+// GetAdminUserByOIDCSubject returns the user linked to an OpenID Connect
+// subject, or nil if there is none.
+func (db *DB) GetAdminUserByOIDCSubject(subject string) (*models.AdminUser, error) {
+	query := `SELECT ` + adminUserColumns + ` FROM admin_users WHERE oidc_subject = ? AND oidc_subject != ''`
+	user, err := scanAdminUser(db.conn.QueryRow(query, subject))
 	if err != nil {
-		return false, fmt.Errorf("failed to count admin users: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get admin user: %w", err)
 	}
-	return count > 0, nil
+
+	return user, nil
 }
 
-// API Key methods
-func (db *DB) CreateAPIKey(name string) (*models.APIKey, string, error) {
-	// Generate random API key
-	keyBytes := make([]byte, 32)
-	if _, err := rand.Read(keyBytes); err != nil {
-		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
-	}
-	apiKey := hex.EncodeToString(keyBytes)
+// CreateOIDCAdminUser creates a user who signs in through OpenID Connect.
+func (db *DB) CreateOIDCAdminUser(username, subject, role string) (*models.AdminUser, error) {
+	return db.createExternalAdminUser(username, role, subject, false)
+}
 
-	// Hash the key for storage
-	hash := sha256.Sum256([]byte(apiKey))
-	keyHash := hex.EncodeToString(hash[:])
+// createExternalAdminUser inserts a user who is authenticated by another
+// system, together with its link to that system, so that a failure can't
+// leave an unlinked account holding the username. The account gets a
+// random password nobody knows.
+func (db *DB) createExternalAdminUser(username, role, oidcSubject string, proxyAuth bool) (*models.AdminUser, error) {
+	password, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
 
-	query := `INSERT INTO api_keys (key_hash, name) VALUES (?, ?)`
-	result, err := db.conn.Exec(query, keyHash, name)
+	query := `INSERT INTO admin_users (username, password_hash, role, oidc_subject, proxy_auth) VALUES (?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, username, string(hashedPassword), role, oidcSubject, proxyAuth)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+		return nil, fmt.Errorf("failed to create admin user: %w", err)
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get last insert id: %w", err)
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
 
-	return &models.APIKey{
-		ID:        int(id),
-		KeyHash:   keyHash,
-		Name:      name,
-		Enabled:   true,
-		CreatedAt: time.Now(),
-	}, apiKey, nil
+	return &models.AdminUser{
+		ID:           int(id),
+		Username:     username,
+		PasswordHash: string(hashedPassword),
+		Role:         role,
+		Enabled:      true,
+		OIDCSubject:  oidcSubject,
+		ProxyAuth:    proxyAuth,
+		CreatedAt:    time.Now(),
+	}, nil
 }
 
-func (db *DB) GetAPIKeyByKey(apiKey string) (*models.APIKey, error) {
-	hash := sha256.Sum256([]byte(apiKey))
-	keyHash := hex.EncodeToString(hash[:])
-
-	query := `SELECT id, key_hash, name, enabled, created_at, last_used FROM api_keys WHERE key_hash = ? AND enabled = 1`
-	row := db.conn.QueryRow(query, keyHash)
+// CreateProxyAdminUser creates a user who is signed in by an authenticating
+// reverse proxy.
+func (db *DB) CreateProxyAdminUser(username, role string) (*models.AdminUser, error) {
+	return db.createExternalAdminUser(username, role, "", true)
+}
 
-	var key models.APIKey
-	var lastUsed sql.NullTime
-	err := row.Scan(&key.ID, &key.KeyHash, &key.Name, &key.Enabled, &key.CreatedAt, &lastUsed)
+func (db *DB) GetAdminUserByID(userID int) (*models.AdminUser, error) {
+	query := `SELECT ` + adminUserColumns + ` FROM admin_users WHERE id = ?`
+	user, err := scanAdminUser(db.conn.QueryRow(query, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("failed to get API key: %w", err)
-	}
-
-	if lastUsed.Valid {
-		key.LastUsed = &lastUsed.Time
+		return nil, fmt.Errorf("failed to get admin user: %w", err)
 	}
 
-	return &key, nil
+	return user, nil
 }
 
-func (db *DB) GetAllAPIKeys() ([]*models.APIKey, error) {
-	query := `SELECT id, key_hash, name, enabled, created_at, last_used FROM api_keys ORDER BY created_at DESC`
+func (db *DB) GetAllAdminUsers() ([]*models.AdminUser, error) {
+	query := `SELECT ` + adminUserColumns + ` FROM admin_users ORDER BY username`
 	rows, err := db.conn.Query(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get API keys: %w", err)
+		return nil, fmt.Errorf("failed to get admin users: %w", err)
 	}
 	defer rows.Close()
 
-	var keys []*models.APIKey
+	var users []*models.AdminUser
 	for rows.Next() {
-		var key models.APIKey
-		var lastUsed sql.NullTime
-		err := rows.Scan(&key.ID, &key.KeyHash, &key.Name, &key.Enabled, &key.CreatedAt, &lastUsed)
+		user, err := scanAdminUser(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan API key: %w", err)
+			return nil, fmt.Errorf("failed to scan admin user: %w", err)
 		}
+		users = append(users, user)
+	}
 
-		if lastUsed.Valid {
-			key.LastUsed = &lastUsed.Time
-		}
+	return users, nil
+}
 
-		keys = append(keys, &key)
+func (db *DB) HasAdminUsers() (bool, error) {
+	query := `SELECT COUNT(*) FROM admin_users`
+	var count int
+	err := db.conn.QueryRow(query).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to count admin users: %w", err)
 	}
-
-	return keys, nil
+	return count > 0, nil
 }
 
-func (db *DB) UpdateAPIKeyLastUsed(keyID int) error {
-	query := `UPDATE api_keys SET last_used = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err := db.conn.Exec(query, keyID)
+func (db *DB) UpdateAdminUserRole(userID int, role string) error {
+	query := `UPDATE admin_users SET role = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, role, userID)
 	if err != nil {
-		return fmt.Errorf("failed to update API key last used: %w", err)
+		return fmt.Errorf("failed to update admin user role: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) UpdateAPIKeyEnabled(keyID int, enabled bool) error {
-	query := `UPDATE api_keys SET enabled = ? WHERE id = ?`
-	_, err := db.conn.Exec(query, enabled, keyID)
+// UpdateAdminUserEnabled enables or disables a user. Disabling also signs
+// out every session for that user.
+func (db *DB) UpdateAdminUserEnabled(userID int, enabled bool) error {
+	query := `UPDATE admin_users SET enabled = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, enabled, userID)
 	if err != nil {
-		return fmt.Errorf("failed to update API key enabled status: %w", err)
+		return fmt.Errorf("failed to update admin user: %w", err)
+	}
+	if !enabled {
+		return db.DeleteAdminSessionsForUser(userID, 0)
 	}
 	return nil
 }
 
-func (db *DB) DeleteAPIKey(keyID int) error {
-	query := `DELETE FROM api_keys WHERE id = ?`
-	_, err := db.conn.Exec(query, keyID)
+// UpdateAdminUserPassword sets a new password, deletes every session for
+// that user and bumps their session version so pending sign-ins started with
+// the old password are rejected too.
+func (db *DB) UpdateAdminUserPassword(userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return fmt.Errorf("failed to delete API key: %w", err)
+		return fmt.Errorf("failed to hash password: %w", err)
 	}
-	return nil
+
+	query := `UPDATE admin_users SET password_hash = ?, session_version = session_version + 1 WHERE id = ?`
+	if _, err := db.conn.Exec(query, string(hashedPassword), userID); err != nil {
+		return fmt.Errorf("failed to update admin user password: %w", err)
+	}
+	return db.DeleteAdminSessionsForUser(userID, 0)
 }
 
-// API Request methods
-func (db *DB) LogAPIRequest(keyID, imageCount int) error {
-	query := `INSERT INTO api_requests (api_key_id, image_count) VALUES (?, ?)`
-	_, err := db.conn.Exec(query, keyID, imageCount)
+func (db *DB) DeleteAdminUser(userID int) error {
+	if _, err := db.conn.Exec(`DELETE FROM admin_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := db.DeleteAdminSessionsForUser(userID, 0); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM admin_users WHERE id = ?`
+	_, err := db.conn.Exec(query, userID)
 	if err != nil {
-		return fmt.Errorf("failed to log API request: %w", err)
+		return fmt.Errorf("failed to delete admin user: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) GetAPIKeyUsageCount(keyID int) (int, error) {
-	query := `SELECT COUNT(*) FROM api_requests WHERE api_key_id = ?`
-	var count int
-	err := db.conn.QueryRow(query, keyID).Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get API key usage count: %w", err)
+// SetAdminUserTOTPSecret stores a secret for an enrollment in progress. It
+// takes effect once EnableAdminUserTOTP confirms the user can produce codes.
+func (db *DB) SetAdminUserTOTPSecret(userID int, secret string) error {
+	query := `UPDATE admin_users SET totp_secret = ?, totp_enabled = 0, totp_last_counter = 0 WHERE id = ?`
+	if _, err := db.conn.Exec(query, secret, userID); err != nil {
+		return fmt.Errorf("failed to set TOTP secret: %w", err)
 	}
-	return count, nil
+	return nil
 }
 
-// Image File methods
-func (db *DB) CreateImageFile(filename string, size int64, mimeType string) (*models.ImageFile, error) {
-	query := `INSERT INTO image_files (filename, size, mime_type, enabled) VALUES (?, ?, ?, ?)`
-	result, err := db.conn.Exec(query, filename, size, mimeType, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create image file record: %w", err)
+func (db *DB) EnableAdminUserTOTP(userID int, counter int64) error {
+	query := `UPDATE admin_users SET totp_enabled = 1, totp_last_counter = ? WHERE id = ? AND totp_secret != ''`
+	if _, err := db.conn.Exec(query, counter, userID); err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
 	}
+	return nil
+}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+// DisableAdminUserTOTP removes the user's TOTP secret and recovery codes.
+func (db *DB) DisableAdminUserTOTP(userID int) error {
+	query := `UPDATE admin_users SET totp_secret = '', totp_enabled = 0, totp_last_counter = 0 WHERE id = ?`
+	if _, err := db.conn.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	if _, err := db.conn.Exec(`DELETE FROM admin_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
 	}
+	return nil
+}
 
-	return &models.ImageFile{
-		ID:         int(id),
-		Filename:   filename,
-		Size:       size,
-		MimeType:   mimeType,
-		Enabled:    true,
-		UploadedAt: time.Now(),
-	}, nil
+// UseTOTPCounter records counter as the last used time step, reporting false
+// if it is not newer than the stored one (the code was already used).
+func (db *DB) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	query := `UPDATE admin_users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?`
+	result, err := db.conn.Exec(query, counter, userID, counter)
+	if err != nil {
+		return false, fmt.Errorf("failed to update TOTP counter: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update TOTP counter: %w", err)
+	}
+	return rows == 1, nil
 }
 
-func (db *DB) GetAllImageFiles() ([]*models.ImageFile, error) {
-	query := `SELECT id, filename, size, mime_type, enabled, uploaded_at FROM image_files ORDER BY uploaded_at DESC`
-	rows, err := db.conn.Query(query)
+// ReplaceRecoveryCodes discards the user's recovery codes and stores hashes of codes.
+func (db *DB) ReplaceRecoveryCodes(userID int, codes []string) error {
+	tx, err := db.conn.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image files: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var images []*models.ImageFile
-	for rows.Next() {
-		var img models.ImageFile
-		err := rows.Scan(&img.ID, &img.Filename, &img.Size, &img.MimeType, &img.Enabled, &img.UploadedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan image file: %w", err)
+	if _, err := tx.Exec(`DELETE FROM admin_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(`INSERT INTO admin_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashRecoveryCode(code)); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
 		}
-		images = append(images, &img)
 	}
 
-	return images, nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return nil
 }
 
-func (db *DB) GetRandomImageFiles(count int) ([]*models.ImageFile, error) {
-	query := `SELECT id, filename, size, mime_type, enabled, uploaded_at FROM image_files WHERE enabled = 1 ORDER BY RANDOM() LIMIT ?`
-	rows, err := db.conn.Query(query, count)
+// UseRecoveryCode marks an unused recovery code as used, reporting whether it was valid.
+func (db *DB) UseRecoveryCode(userID int, code string) (bool, error) {
+	query := `UPDATE admin_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE id = (SELECT id FROM admin_recovery_codes WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1)`
+	result, err := db.conn.Exec(query, userID, hashRecoveryCode(code))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get random image files: %w", err)
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
 	}
-	defer rows.Close()
-
-	var images []*models.ImageFile
-	for rows.Next() {
-		var img models.ImageFile
-		err := rows.Scan(&img.ID, &img.Filename, &img.Size, &img.MimeType, &img.Enabled, &img.UploadedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan image file: %w", err)
-		}
-		images = append(images, &img)
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
 	}
-
-	return images, nil
+	return rows == 1, nil
 }
 
-func (db *DB) GetImageFileCount() (int, error) {
-	query := `SELECT COUNT(*) FROM image_files WHERE enabled = 1`
+func (db *DB) CountUnusedRecoveryCodes(userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM admin_recovery_codes WHERE user_id = ? AND used_at IS NULL`
 	var count int
-	err := db.conn.QueryRow(query).Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get image file count: %w", err)
+	if err := db.conn.QueryRow(query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
 	}
 	return count, nil
 }
 
-func (db *DB) DeleteImageFile(filename string) error {
-	query := `DELETE FROM image_files WHERE filename = ?`
-	_, err := db.conn.Exec(query, filename)
+// hashRecoveryCode hashes a normalized recovery code. The codes are random,
+// so an unsalted hash is sufficient, as for API keys.
+func hashRecoveryCode(code string) string {
+	return hashAPIKey(totp.NormalizeRecoveryCode(code))
+}
+
+// API Key methods
+const apiKeyColumns = `id, key_hash, name, enabled, scopes, created_at, last_used,
+	rate_limit_per_second, rate_limit_burst, daily_image_quota, monthly_image_quota,
+	expires_at, COALESCE(previous_key_hash, ''), previous_key_expires_at,
+	allowed_origins, allowed_referrers, allowed_ips, allowed_image_ids`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes, origins, referrers, ips, imageIDs string
+	var lastUsed, expiresAt, previousKeyExpiresAt sql.NullTime
+	err := row.Scan(&key.ID, &key.KeyHash, &key.Name, &key.Enabled, &scopes, &key.CreatedAt, &lastUsed,
+		&key.RateLimitPerSecond, &key.RateLimitBurst, &key.DailyImageQuota, &key.MonthlyImageQuota,
+		&expiresAt, &key.PreviousKeyHash, &previousKeyExpiresAt,
+		&origins, &referrers, &ips, &imageIDs)
 	if err != nil {
-		return fmt.Errorf("failed to delete image file record: %w", err)
+		return nil, err
 	}
-	return nil
+
+	key.Scopes = splitList(scopes)
+	key.AllowedOrigins = splitList(origins)
+	key.AllowedReferrers = splitList(referrers)
+	key.AllowedIPs = splitList(ips)
+	for _, id := range splitList(imageIDs) {
+		if imageID, err := strconv.Atoi(id); err == nil {
+			key.AllowedImageIDs = append(key.AllowedImageIDs, imageID)
+		}
+	}
+	if lastUsed.Valid {
+		key.LastUsed = &lastUsed.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if previousKeyExpiresAt.Valid {
+		key.PreviousKeyExpiresAt = &previousKeyExpiresAt.Time
+	}
+
+	return &key, nil
 }
 
-func (db *DB) UpdateImageFilename(oldFilename, newFilename string) error {
-	query := `UPDATE image_files SET filename = ? WHERE filename = ?`
-	_, err := db.conn.Exec(query, newFilename, oldFilename)
-	if err != nil {
-		return fmt.Errorf("failed to update image filename: %w", err)
+// splitList parses a comma or newline separated list column.
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
 	}
-	return nil
+	return result
 }
 
-func (db *DB) UpdateImageEnabled(filename string, enabled bool) error {
-	query := `UPDATE image_files SET enabled = ? WHERE filename = ?`
-	_, err := db.conn.Exec(query, enabled, filename)
-	if err != nil {
-		return fmt.Errorf("failed to update image enabled status: %w", err)
+func joinList(items []string) string {
+	return strings.Join(items, ",")
+}
+
+func joinIDs(ids []int) string {
+	items := make([]string, len(ids))
+	for i, id := range ids {
+		items[i] = strconv.Itoa(id)
 	}
-	return nil
+	return joinList(items)
 }
 
-func (db *DB) GetTotalImageFileCount() (int, error) {
-	query := `SELECT COUNT(*) FROM image_files`
-	var count int
-	err := db.conn.QueryRow(query).Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get total image file count: %w", err)
+// inClause returns a SQL fragment "column IN (?, ...)" and its arguments.
+func inClause(column string, ids []int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
 	}
-	return count, nil
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+func hashAPIKey(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateAPIKey() (string, error) {
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(keyBytes), nil
+}
+
+// nullableTime converts an optional time to a value SQLite compares
+// consistently with CURRENT_TIMESTAMP.
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// parseTime parses a timestamp stored by SQLite's CURRENT_TIMESTAMP or formatTime.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+func (db *DB) CreateAPIKey(name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	return db.CreateRestrictedAPIKey(&models.APIKey{Name: name, Scopes: scopes, ExpiresAt: expiresAt})
+}
+
+// CreateRestrictedAPIKey creates a key with the name, scopes, expiry,
+// restrictions and limits of key, in a single statement so the key is never
+// usable without them.
+func (db *DB) CreateRestrictedAPIKey(key *models.APIKey) (*models.APIKey, string, error) {
+	// Generate random API key
+	apiKey, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Hash the key for storage
+	keyHash := hashAPIKey(apiKey)
+
+	query := `INSERT INTO api_keys (key_hash, name, scopes, expires_at,
+		allowed_origins, allowed_referrers, allowed_ips, allowed_image_ids,
+		rate_limit_per_second, rate_limit_burst, daily_image_quota, monthly_image_quota)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, keyHash, key.Name, joinList(key.Scopes), nullableTime(key.ExpiresAt),
+		joinList(key.AllowedOrigins), joinList(key.AllowedReferrers), joinList(key.AllowedIPs), joinIDs(key.AllowedImageIDs),
+		key.RateLimitPerSecond, key.RateLimitBurst, key.DailyImageQuota, key.MonthlyImageQuota)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	created := *key
+	created.ID = int(id)
+	created.KeyHash = keyHash
+	created.Enabled = true
+	created.CreatedAt = time.Now()
+	return &created, apiKey, nil
+}
+
+// GetAPIKeyByKey looks up an enabled, unexpired key by its current secret or
+// by a rotated-out secret that is still within its grace period.
+func (db *DB) GetAPIKeyByKey(apiKey string) (*models.APIKey, error) {
+	keyHash := hashAPIKey(apiKey)
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE (key_hash = ? OR (previous_key_hash = ? AND previous_key_expires_at > CURRENT_TIMESTAMP))
+		AND enabled = 1
+		AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+	key, err := scanAPIKey(db.conn.QueryRow(query, keyHash, keyHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (db *DB) GetAPIKeyByID(keyID int) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = ?`
+	key, err := scanAPIKey(db.conn.QueryRow(query, keyID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (db *DB) GetAllAPIKeys() ([]*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (db *DB) UpdateAPIKeyLastUsed(keyID int) error {
+	query := `UPDATE api_keys SET last_used = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := db.conn.Exec(query, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key last used: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateAPIKeyEnabled(keyID int, enabled bool) error {
+	query := `UPDATE api_keys SET enabled = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, enabled, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key enabled status: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateAPIKeyScopes(keyID int, scopes []string) error {
+	query := `UPDATE api_keys SET scopes = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, joinList(scopes), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key scopes: %w", err)
+	}
+	return nil
+}
+
+// RotateAPIKey replaces a key's secret while keeping its ID and usage
+// history. The old secret remains valid for gracePeriod; a zero grace
+// period invalidates it immediately.
+func (db *DB) RotateAPIKey(keyID int, gracePeriod time.Duration) (string, error) {
+	apiKey, err := generateAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	var result sql.Result
+	if gracePeriod > 0 {
+		query := `UPDATE api_keys SET previous_key_hash = key_hash, previous_key_expires_at = ?, key_hash = ? WHERE id = ?`
+		result, err = db.conn.Exec(query, formatTime(time.Now().Add(gracePeriod)), hashAPIKey(apiKey), keyID)
+	} else {
+		query := `UPDATE api_keys SET previous_key_hash = NULL, previous_key_expires_at = NULL, key_hash = ? WHERE id = ?`
+		result, err = db.conn.Exec(query, hashAPIKey(apiKey), keyID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate API key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if affected == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return apiKey, nil
+}
+
+func (db *DB) UpdateAPIKeyExpiry(keyID int, expiresAt *time.Time) error {
+	query := `UPDATE api_keys SET expires_at = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, nullableTime(expiresAt), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key expiry: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateAPIKeyRestrictions(keyID int, origins, referrers, ips []string, imageIDs []int) error {
+	query := `UPDATE api_keys SET allowed_origins = ?, allowed_referrers = ?, allowed_ips = ?, allowed_image_ids = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, joinList(origins), joinList(referrers), joinList(ips), joinIDs(imageIDs), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key restrictions: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateAPIKeyLimits(keyID int, ratePerSecond float64, burst, dailyQuota, monthlyQuota int) error {
+	query := `UPDATE api_keys SET rate_limit_per_second = ?, rate_limit_burst = ?, daily_image_quota = ?, monthly_image_quota = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, ratePerSecond, burst, dailyQuota, monthlyQuota, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update API key limits: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteAPIKey(keyID int) error {
+	query := `DELETE FROM api_keys WHERE id = ?`
+	_, err := db.conn.Exec(query, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+	return nil
+}
+
+// API Request methods
+func (db *DB) LogAPIRequest(keyID, imageCount int) error {
+	query := `INSERT INTO api_requests (api_key_id, image_count) VALUES (?, ?)`
+	_, err := db.conn.Exec(query, keyID, imageCount)
+	if err != nil {
+		return fmt.Errorf("failed to log API request: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyImageUsageSince sums the images served to a key since the given time.
+func (db *DB) GetAPIKeyImageUsageSince(keyID int, since time.Time) (int, error) {
+	query := `SELECT COALESCE(SUM(image_count), 0) FROM api_requests WHERE api_key_id = ? AND timestamp >= ?`
+	var count int
+	err := db.conn.QueryRow(query, keyID, formatTime(since)).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get API key image usage: %w", err)
+	}
+	return count, nil
+}
+
+func (db *DB) GetTotalAPIRequestCount() (int, error) {
+	query := `SELECT COUNT(*) FROM api_requests`
+	var count int
+	err := db.conn.QueryRow(query).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total API request count: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteAPIRequestsBefore deletes API request log entries made before
+// cutoff, returning how many were deleted.
+func (db *DB) DeleteAPIRequestsBefore(cutoff time.Time) (int64, error) {
+	result, err := db.conn.Exec(`DELETE FROM api_requests WHERE timestamp < ?`, formatTime(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete API requests: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted API requests: %w", err)
+	}
+	return deleted, nil
+}
+
+// Usage tables by interval
+var usageTables = map[string]string{
+	models.UsageIntervalHour: "usage_hourly",
+	models.UsageIntervalDay:  "usage_daily",
+}
+
+func usageTable(interval string) (string, error) {
+	table, ok := usageTables[interval]
+	if !ok {
+		return "", fmt.Errorf("unknown usage interval: %s", interval)
+	}
+	return table, nil
+}
+
+// AddUsage adds to the hourly and daily API usage in a single transaction.
+func (db *DB) AddUsage(usage map[models.UsageKey]models.UsageCounts) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"usage_hourly", "usage_daily"} {
+		stmt, err := tx.Prepare(`INSERT INTO ` + table + ` (bucket, api_key_id, requests, images_served, errors)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (bucket, api_key_id) DO UPDATE SET
+				requests = requests + excluded.requests,
+				images_served = images_served + excluded.images_served,
+				errors = errors + excluded.errors`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare usage update: %w", err)
+		}
+		defer stmt.Close()
+
+		for key, counts := range usage {
+			bucket := key.Hour.UTC().Truncate(time.Hour)
+			if table == "usage_daily" {
+				bucket = bucket.Truncate(24 * time.Hour)
+			}
+			_, err := stmt.Exec(formatTime(bucket), key.APIKeyID, counts.Requests, counts.ImagesServed, counts.Errors)
+			if err != nil {
+				return fmt.Errorf("failed to update usage: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit usage: %w", err)
+	}
+	return nil
+}
+
+// GetUsageSeries returns the API usage in each hour or day from from up to
+// but excluding to. Buckets without usage are left out.
+func (db *DB) GetUsageSeries(interval string, from, to time.Time) ([]models.UsageBucket, error) {
+	table, err := usageTable(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT bucket, SUM(requests), SUM(images_served), SUM(errors),
+		COUNT(DISTINCT NULLIF(api_key_id, 0))
+		FROM ` + table + ` WHERE bucket >= ? AND bucket < ?
+		GROUP BY bucket ORDER BY bucket`
+	rows, err := db.conn.Query(query, formatTime(from), formatTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %w", err)
+	}
+	defer rows.Close()
+
+	var series []models.UsageBucket
+	for rows.Next() {
+		var b models.UsageBucket
+		var bucket string
+		if err := rows.Scan(&bucket, &b.Requests, &b.ImagesServed, &b.Errors, &b.UniqueKeys); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		if b.Start, err = parseTime(bucket); err != nil {
+			return nil, fmt.Errorf("failed to parse usage bucket: %w", err)
+		}
+		series = append(series, b)
+	}
+	return series, rows.Err()
+}
+
+// GetUsageTotal returns the API usage from from up to but excluding to.
+func (db *DB) GetUsageTotal(interval string, from, to time.Time) (*models.UsageBucket, error) {
+	table, err := usageTable(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(images_served), 0), COALESCE(SUM(errors), 0),
+		COUNT(DISTINCT NULLIF(api_key_id, 0))
+		FROM ` + table + ` WHERE bucket >= ? AND bucket < ?`
+	total := models.UsageBucket{Start: from}
+	err = db.conn.QueryRow(query, formatTime(from), formatTime(to)).Scan(&total.Requests, &total.ImagesServed, &total.Errors, &total.UniqueKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage total: %w", err)
+	}
+	return &total, nil
+}
+
+// GetAllTimeUsage returns the API usage since usage was first recorded.
+func (db *DB) GetAllTimeUsage() (*models.UsageCounts, error) {
+	query := `SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(images_served), 0), COALESCE(SUM(errors), 0) FROM usage_daily`
+	var counts models.UsageCounts
+	if err := db.conn.QueryRow(query).Scan(&counts.Requests, &counts.ImagesServed, &counts.Errors); err != nil {
+		return nil, fmt.Errorf("failed to get usage total: %w", err)
+	}
+	return &counts, nil
+}
+
+// GetAllTimeUsageByKey returns each API key's usage since usage was first
+// recorded, keyed by API key ID.
+func (db *DB) GetAllTimeUsageByKey() (map[int]models.UsageCounts, error) {
+	query := `SELECT api_key_id, SUM(requests), SUM(images_served), SUM(errors) FROM usage_daily GROUP BY api_key_id`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage by key: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[int]models.UsageCounts)
+	for rows.Next() {
+		var keyID int
+		var counts models.UsageCounts
+		if err := rows.Scan(&keyID, &counts.Requests, &counts.ImagesServed, &counts.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan usage by key: %w", err)
+		}
+		usage[keyID] = counts
+	}
+	return usage, rows.Err()
+}
+
+// GetUsageByKey returns each API key's usage from from up to but excluding
+// to, busiest first.
+func (db *DB) GetUsageByKey(interval string, from, to time.Time) ([]models.KeyUsage, error) {
+	table, err := usageTable(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT u.api_key_id, COALESCE(k.name, ''), SUM(u.requests), SUM(u.images_served), SUM(u.errors)
+		FROM ` + table + ` u LEFT JOIN api_keys k ON k.id = u.api_key_id
+		WHERE u.bucket >= ? AND u.bucket < ?
+		GROUP BY u.api_key_id ORDER BY SUM(u.requests) DESC, u.api_key_id`
+	rows, err := db.conn.Query(query, formatTime(from), formatTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage by key: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []models.KeyUsage
+	for rows.Next() {
+		var u models.KeyUsage
+		if err := rows.Scan(&u.APIKeyID, &u.Name, &u.Requests, &u.ImagesServed, &u.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan usage by key: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// Admin session methods
+const adminSessionColumns = `s.id, s.user_id, u.username, s.auth_method, s.user_agent, s.ip_address, s.created_at, s.last_seen_at`
+
+func scanAdminSession(row rowScanner) (*models.AdminSession, error) {
+	var session models.AdminSession
+	if err := row.Scan(&session.ID, &session.UserID, &session.Username, &session.AuthMethod, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastSeenAt); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreateAdminSession starts a session for userID and returns it along with
+// the token to put in the session cookie. Only a hash of the token is stored.
+func (db *DB) CreateAdminSession(userID int, authMethod, userAgent, ipAddress string) (*models.AdminSession, string, error) {
+	token, err := generateAPIKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	query := `INSERT INTO admin_sessions (token_hash, user_id, auth_method, user_agent, ip_address) VALUES (?, ?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, hashAPIKey(token), userID, authMethod, userAgent, ipAddress)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create admin session: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	now := time.Now().UTC()
+	return &models.AdminSession{
+		ID:         int(id),
+		UserID:     userID,
+		AuthMethod: authMethod,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}, token, nil
+}
+
+func (db *DB) GetAdminSessionByToken(token string) (*models.AdminSession, error) {
+	query := `SELECT ` + adminSessionColumns + ` FROM admin_sessions s
+		JOIN admin_users u ON u.id = s.user_id WHERE s.token_hash = ?`
+	session, err := scanAdminSession(db.conn.QueryRow(query, hashAPIKey(token)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get admin session: %w", err)
+	}
+	return session, nil
+}
+
+func (db *DB) GetAdminSessionByID(sessionID int) (*models.AdminSession, error) {
+	query := `SELECT ` + adminSessionColumns + ` FROM admin_sessions s
+		JOIN admin_users u ON u.id = s.user_id WHERE s.id = ?`
+	session, err := scanAdminSession(db.conn.QueryRow(query, sessionID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get admin session: %w", err)
+	}
+	return session, nil
+}
+
+// GetActiveAdminSessions returns sessions last seen after seenSince and
+// created after createdSince, most recently used first. A userID of 0
+// returns the sessions of every user.
+func (db *DB) GetActiveAdminSessions(userID int, seenSince, createdSince time.Time) ([]*models.AdminSession, error) {
+	query := `SELECT ` + adminSessionColumns + ` FROM admin_sessions s
+		JOIN admin_users u ON u.id = s.user_id
+		WHERE s.last_seen_at >= ? AND s.created_at >= ? AND (? = 0 OR s.user_id = ?)
+		ORDER BY s.last_seen_at DESC, s.id DESC`
+	rows, err := db.conn.Query(query, formatTime(seenSince), formatTime(createdSince), userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get admin sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.AdminSession
+	for rows.Next() {
+		session, err := scanAdminSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan admin session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// TouchAdminSession records that a session was just used from ipAddress.
+func (db *DB) TouchAdminSession(sessionID int, ipAddress string) error {
+	query := `UPDATE admin_sessions SET last_seen_at = CURRENT_TIMESTAMP, ip_address = ? WHERE id = ?`
+	if _, err := db.conn.Exec(query, ipAddress, sessionID); err != nil {
+		return fmt.Errorf("failed to update admin session: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteAdminSession(sessionID int) error {
+	query := `DELETE FROM admin_sessions WHERE id = ?`
+	if _, err := db.conn.Exec(query, sessionID); err != nil {
+		return fmt.Errorf("failed to delete admin session: %w", err)
+	}
+	return nil
+}
+
+// DeleteAdminSessionsForUser signs out every session for userID except exceptID.
+func (db *DB) DeleteAdminSessionsForUser(userID, exceptID int) error {
+	query := `DELETE FROM admin_sessions WHERE user_id = ? AND id != ?`
+	if _, err := db.conn.Exec(query, userID, exceptID); err != nil {
+		return fmt.Errorf("failed to delete admin sessions: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredAdminSessions removes sessions last seen before seenBefore or
+// created before createdBefore.
+func (db *DB) DeleteExpiredAdminSessions(seenBefore, createdBefore time.Time) error {
+	query := `DELETE FROM admin_sessions WHERE last_seen_at < ? OR created_at < ?`
+	if _, err := db.conn.Exec(query, formatTime(seenBefore), formatTime(createdBefore)); err != nil {
+		return fmt.Errorf("failed to delete expired admin sessions: %w", err)
+	}
+	return nil
+}
+
+// Login attempt methods
+func (db *DB) RecordLoginAttempt(username, ipAddress, stage, result string) error {
+	query := `INSERT INTO login_attempts (username, ip_address, stage, result) VALUES (?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, username, ipAddress, stage, result)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// GetRecentLoginAttempts returns up to limit login attempts, newest first.
+func (db *DB) GetRecentLoginAttempts(limit int) ([]*models.LoginAttempt, error) {
+	query := `SELECT id, username, ip_address, stage, result, created_at FROM login_attempts
+		ORDER BY id DESC LIMIT ?`
+	rows, err := db.conn.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []*models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IPAddress, &attempt.Stage, &attempt.Result, &attempt.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan login attempt: %w", err)
+		}
+		attempts = append(attempts, &attempt)
+	}
+
+	return attempts, nil
+}
+
+// GetLoginFailuresByUsername returns failed sign-ins since the given time
+// for each username, counting only failures after its last success.
+func (db *DB) GetLoginFailuresByUsername(since time.Time) ([]*models.LoginFailures, error) {
+	return db.getLoginFailures("username", "", since)
+}
+
+// GetLoginFailuresByIP is GetLoginFailuresByUsername grouped by IP address.
+func (db *DB) GetLoginFailuresByIP(since time.Time) ([]*models.LoginFailures, error) {
+	return db.getLoginFailures("ip_address", "", since)
+}
+
+// GetUsernameLoginFailures returns the failures for a single username, or nil if there are none.
+func (db *DB) GetUsernameLoginFailures(username string, since time.Time) (*models.LoginFailures, error) {
+	return db.getSingleLoginFailures("username", username, since)
+}
+
+// GetIPLoginFailures returns the failures for a single IP address, or nil if there are none.
+func (db *DB) GetIPLoginFailures(ipAddress string, since time.Time) (*models.LoginFailures, error) {
+	return db.getSingleLoginFailures("ip_address", ipAddress, since)
+}
+
+func (db *DB) getSingleLoginFailures(column, value string, since time.Time) (*models.LoginFailures, error) {
+	failures, err := db.getLoginFailures(column, value, since)
+	if err != nil || len(failures) == 0 {
+		return nil, err
+	}
+	return failures[0], nil
+}
+
+// getLoginFailures groups failures by column, which must be username or
+// ip_address. A non-empty value limits the result to that one group.
+func (db *DB) getLoginFailures(column, value string, since time.Time) ([]*models.LoginFailures, error) {
+	query := `SELECT la.` + column + `, COUNT(*), MAX(la.created_at) FROM login_attempts la
+		WHERE la.result = ? AND la.created_at >= ? AND la.` + column + ` != ''
+		AND la.id > COALESCE((SELECT MAX(s.id) FROM login_attempts s
+			WHERE s.` + column + ` = la.` + column + ` AND s.result IN (?, ?)), 0)`
+	args := []interface{}{models.LoginResultFailure, formatTime(since), models.LoginResultSuccess, models.LoginResultUnlocked}
+	if value != "" {
+		query += ` AND la.` + column + ` = ?`
+		args = append(args, value)
+	}
+	query += ` GROUP BY la.` + column
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.LoginFailures
+	for rows.Next() {
+		var failures models.LoginFailures
+		var lastFailure string
+		if err := rows.Scan(&failures.Value, &failures.Count, &lastFailure); err != nil {
+			return nil, fmt.Errorf("failed to scan login failures: %w", err)
+		}
+		// MAX() loses the column type, so the timestamp comes back as text
+		failures.LastFailure, err = parseTime(lastFailure)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse login failure time: %w", err)
+		}
+		results = append(results, &failures)
+	}
+
+	return results, nil
+}
+
+// Image File methods
+const imageFileColumns = `id, filename, size, mime_type, enabled, uploaded_at, deleted_at, collection,
+	title, alt_text, attribution, source_url, license, publish_at, unpublish_at,
+	served_count, fetched_count, last_served_at, last_fetched_at`
+
+// publishedCondition selects images inside their publishing window.
+const publishedCondition = `(publish_at IS NULL OR publish_at <= CURRENT_TIMESTAMP)
+	AND (unpublish_at IS NULL OR unpublish_at > CURRENT_TIMESTAMP)`
+
+func scanImageFile(row rowScanner) (*models.ImageFile, error) {
+	var img models.ImageFile
+	var deletedAt, publishAt, unpublishAt, lastServedAt, lastFetchedAt sql.NullTime
+	err := row.Scan(&img.ID, &img.Filename, &img.Size, &img.MimeType, &img.Enabled, &img.UploadedAt, &deletedAt, &img.Collection,
+		&img.Title, &img.AltText, &img.Attribution, &img.SourceURL, &img.License, &publishAt, &unpublishAt,
+		&img.ServedCount, &img.FetchedCount, &lastServedAt, &lastFetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		img.DeletedAt = &deletedAt.Time
+	}
+	if publishAt.Valid {
+		img.PublishAt = &publishAt.Time
+	}
+	if unpublishAt.Valid {
+		img.UnpublishAt = &unpublishAt.Time
+	}
+	if lastServedAt.Valid {
+		img.LastServedAt = &lastServedAt.Time
+	}
+	if lastFetchedAt.Valid {
+		img.LastFetchedAt = &lastFetchedAt.Time
+	}
+	return &img, nil
+}
+
+func (db *DB) CreateImageFile(filename string, size int64, mimeType string) (*models.ImageFile, error) {
+	query := `INSERT INTO image_files (filename, size, mime_type, enabled) VALUES (?, ?, ?, ?)`
+	result, err := db.conn.Exec(query, filename, size, mimeType, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image file record: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	return &models.ImageFile{
+		ID:         int(id),
+		Filename:   filename,
+		Size:       size,
+		MimeType:   mimeType,
+		Enabled:    true,
+		UploadedAt: time.Now(),
+	}, nil
+}
+
+func (db *DB) GetAllImageFiles() ([]*models.ImageFile, error) {
+	query := `SELECT ` + imageFileColumns + ` FROM image_files WHERE deleted_at IS NULL ORDER BY uploaded_at DESC`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image files: %w", err)
+	}
+	defer rows.Close()
+
+	var images []*models.ImageFile
+	for rows.Next() {
+		img, err := scanImageFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan image file: %w", err)
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+func (db *DB) GetImageFileByFilename(filename string) (*models.ImageFile, error) {
+	query := `SELECT ` + imageFileColumns + ` FROM image_files WHERE filename = ? AND deleted_at IS NULL`
+	img, err := scanImageFile(db.conn.QueryRow(query, filename))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get image file: %w", err)
+	}
+
+	return img, nil
+}
+
+// GetRandomImageFiles picks up to count enabled images inside their
+// publishing window at random. If imageIDs is non-empty, only those images
+// are considered.
+func (db *DB) GetRandomImageFiles(count int, imageIDs []int) ([]*models.ImageFile, error) {
+	query := `SELECT ` + imageFileColumns + ` FROM image_files WHERE enabled = 1 AND deleted_at IS NULL AND ` + publishedCondition
+	var args []interface{}
+	if len(imageIDs) > 0 {
+		clause, clauseArgs := inClause("id", imageIDs)
+		query += " AND " + clause
+		args = append(args, clauseArgs...)
+	}
+	query += ` ORDER BY RANDOM() LIMIT ?`
+	args = append(args, count)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get random image files: %w", err)
+	}
+	defer rows.Close()
+
+	var images []*models.ImageFile
+	for rows.Next() {
+		img, err := scanImageFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan image file: %w", err)
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// GetImageFileCount counts the images that can be served: enabled and
+// inside their publishing window.
+func (db *DB) GetImageFileCount() (int, error) {
+	query := `SELECT COUNT(*) FROM image_files WHERE enabled = 1 AND deleted_at IS NULL AND ` + publishedCondition
+	var count int
+	err := db.conn.QueryRow(query).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get image file count: %w", err)
+	}
+	return count, nil
+}
+
+// GetImageFileCountIn counts the images among imageIDs that can be served.
+func (db *DB) GetImageFileCountIn(imageIDs []int) (int, error) {
+	if len(imageIDs) == 0 {
+		return 0, nil
+	}
+	clause, args := inClause("id", imageIDs)
+	query := `SELECT COUNT(*) FROM image_files WHERE enabled = 1 AND deleted_at IS NULL AND ` + publishedCondition + ` AND ` + clause
+	var count int
+	err := db.conn.QueryRow(query, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get image file count: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteImageFile removes an image's record for good, whether or not it is
+// in the trash.
+func (db *DB) DeleteImageFile(filename string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM image_tags WHERE image_id IN (SELECT id FROM image_files WHERE filename = ?)`, filename); err != nil {
+		return fmt.Errorf("failed to delete image tags: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM image_files WHERE filename = ?`, filename); err != nil {
+		return fmt.Errorf("failed to delete image file record: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit image deletion: %w", err)
+	}
+	return nil
+}
+
+// TrashImageFile moves an image to the trash, hiding it everywhere but the
+// trash page. It returns false if there is no such image outside the trash.
+func (db *DB) TrashImageFile(filename string) (bool, error) {
+	query := `UPDATE image_files SET deleted_at = CURRENT_TIMESTAMP WHERE filename = ? AND deleted_at IS NULL`
+	result, err := db.conn.Exec(query, filename)
+	if err != nil {
+		return false, fmt.Errorf("failed to trash image file: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// RestoreImageFile takes an image out of the trash.
+func (db *DB) RestoreImageFile(filename string) error {
+	query := `UPDATE image_files SET deleted_at = NULL WHERE filename = ?`
+	_, err := db.conn.Exec(query, filename)
+	if err != nil {
+		return fmt.Errorf("failed to restore image file: %w", err)
+	}
+	return nil
+}
+
+// GetTrashedImageFile returns an image in the trash, or nil if there is none
+// with that filename.
+func (db *DB) GetTrashedImageFile(filename string) (*models.ImageFile, error) {
+	query := `SELECT ` + imageFileColumns + ` FROM image_files WHERE filename = ? AND deleted_at IS NOT NULL`
+	img, err := scanImageFile(db.conn.QueryRow(query, filename))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get trashed image file: %w", err)
+	}
+	return img, nil
+}
+
+// GetTrashedImageFiles lists images in the trash, most recently deleted first.
+// If before is non-zero, only images deleted before then are returned.
+func (db *DB) GetTrashedImageFiles(before time.Time) ([]*models.ImageFile, error) {
+	query := `SELECT ` + imageFileColumns + ` FROM image_files WHERE deleted_at IS NOT NULL`
+	var args []interface{}
+	if !before.IsZero() {
+		query += ` AND deleted_at < ?`
+		args = append(args, formatTime(before))
+	}
+	query += ` ORDER BY deleted_at DESC`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trashed image files: %w", err)
+	}
+	defer rows.Close()
+
+	var images []*models.ImageFile
+	for rows.Next() {
+		img, err := scanImageFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan image file: %w", err)
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+func (db *DB) UpdateImageFilename(oldFilename, newFilename string) error {
+	query := `UPDATE image_files SET filename = ? WHERE filename = ? AND deleted_at IS NULL`
+	_, err := db.conn.Exec(query, newFilename, oldFilename)
+	if err != nil {
+		return fmt.Errorf("failed to update image filename: %w", err)
+	}
+	return nil
+}
+
+// UpdateImageMetadata replaces the title, alt text and credit of an image
+// outside the trash.
+func (db *DB) UpdateImageMetadata(filename string, meta models.ImageMetadata) error {
+	query := `UPDATE image_files SET title = ?, alt_text = ?, attribution = ?, source_url = ?, license = ?
+		WHERE filename = ? AND deleted_at IS NULL`
+	_, err := db.conn.Exec(query, meta.Title, meta.AltText, meta.Attribution, meta.SourceURL, meta.License, filename)
+	if err != nil {
+		return fmt.Errorf("failed to update image metadata: %w", err)
+	}
+	return nil
+}
+
+// AddImageServeCounts adds to the serve counts of images in a single
+// transaction. counts is keyed by image ID; the last served and fetched
+// times replace the stored ones when set.
+func (db *DB) AddImageServeCounts(counts map[int]models.ImageServeCounts) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE image_files SET
+		served_count = served_count + ?, fetched_count = fetched_count + ?,
+		last_served_at = COALESCE(?, last_served_at), last_fetched_at = COALESCE(?, last_fetched_at)
+		WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare serve count update: %w", err)
+	}
+	defer stmt.Close()
+
+	for imageID, c := range counts {
+		_, err := stmt.Exec(c.ServedCount, c.FetchedCount, nullableTime(c.LastServedAt), nullableTime(c.LastFetchedAt), imageID)
+		if err != nil {
+			return fmt.Errorf("failed to update serve counts: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit serve counts: %w", err)
+	}
+	return nil
+}
+
+// UpdateImageSchedule sets when an image outside the trash starts and stops
+// being served. Nil times leave that end of the window open.
+func (db *DB) UpdateImageSchedule(filename string, publishAt, unpublishAt *time.Time) error {
+	query := `UPDATE image_files SET publish_at = ?, unpublish_at = ? WHERE filename = ? AND deleted_at IS NULL`
+	_, err := db.conn.Exec(query, nullableTime(publishAt), nullableTime(unpublishAt), filename)
+	if err != nil {
+		return fmt.Errorf("failed to update image schedule: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateImageEnabled(filename string, enabled bool) error {
+	query := `UPDATE image_files SET enabled = ? WHERE filename = ? AND deleted_at IS NULL`
+	_, err := db.conn.Exec(query, enabled, filename)
+	if err != nil {
+		return fmt.Errorf("failed to update image enabled status: %w", err)
+	}
+	return nil
+}
+
+// imageSortOrders maps the ImageQuery sort orders to ORDER BY clauses. The
+// id breaks ties so pages don't overlap.
+var imageSortOrders = map[string]string{
+	models.ImageSortNewest:      "uploaded_at DESC, id DESC",
+	models.ImageSortOldest:      "uploaded_at ASC, id ASC",
+	models.ImageSortName:        "filename COLLATE NOCASE ASC, id ASC",
+	models.ImageSortNameDesc:    "filename COLLATE NOCASE DESC, id DESC",
+	models.ImageSortLargest:     "size DESC, id DESC",
+	models.ImageSortSmallest:    "size ASC, id ASC",
+	models.ImageSortMostServed:  "served_count DESC, fetched_count DESC, id DESC",
+	models.ImageSortLeastServed: "served_count ASC, fetched_count ASC, id ASC",
+}
+
+// IsValidImageSort reports whether sort is one of the ImageSort values.
+func IsValidImageSort(sort string) bool {
+	_, ok := imageSortOrders[sort]
+	return ok
+}
+
+// whereBuilder collects the conditions of a WHERE clause and their arguments.
+type whereBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (b *whereBuilder) add(condition string, args ...interface{}) {
+	b.conditions = append(b.conditions, condition)
+	b.args = append(b.args, args...)
+}
+
+// String returns the WHERE clause, or an empty string without conditions.
+func (b *whereBuilder) String() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+// escapeLike escapes the wildcards in a LIKE pattern using \ as the escape
+// character.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func imageQueryWhere(q models.ImageQuery) *whereBuilder {
+	where := &whereBuilder{}
+	where.add("deleted_at IS NULL")
+	if q.Search != "" {
+		pattern := "%" + escapeLike(q.Search) + "%"
+		where.add(`(filename LIKE ? ESCAPE '\' OR title LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if q.Enabled != nil {
+		where.add("enabled = ?", *q.Enabled)
+	}
+	if q.NeverServed {
+		where.add("served_count = 0 AND fetched_count = 0")
+	}
+	switch q.PublishState {
+	case models.PublishStateLive:
+		where.add(publishedCondition)
+	case models.PublishStateScheduled:
+		where.add("publish_at > CURRENT_TIMESTAMP")
+	case models.PublishStateExpired:
+		where.add("unpublish_at <= CURRENT_TIMESTAMP")
+	}
+	if q.MimeType != "" {
+		where.add("mime_type = ?", q.MimeType)
+	}
+	if q.Tag != "" {
+		where.add("id IN (SELECT image_id FROM image_tags WHERE tag = ?)", q.Tag)
+	}
+	if q.Collection != "" {
+		where.add("collection = ?", q.Collection)
+	}
+	if !q.UploadedAfter.IsZero() {
+		where.add("uploaded_at >= ?", formatTime(q.UploadedAfter))
+	}
+	if !q.UploadedBefore.IsZero() {
+		where.add("uploaded_at < ?", formatTime(q.UploadedBefore))
+	}
+	if q.MinSize > 0 {
+		where.add("size >= ?", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		where.add("size <= ?", q.MaxSize)
+	}
+	return where
+}
+
+// QueryImageFiles returns the page of images outside the trash selected by q,
+// with their tags, and how many images match in total.
+func (db *DB) QueryImageFiles(q models.ImageQuery) (*models.ImagePage, error) {
+	where := imageQueryWhere(q)
+
+	page := &models.ImagePage{}
+	countQuery := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM image_files` + where.String()
+	if err := db.conn.QueryRow(countQuery, where.args...).Scan(&page.Total, &page.TotalSize); err != nil {
+		return nil, fmt.Errorf("failed to count image files: %w", err)
+	}
+
+	order, ok := imageSortOrders[q.Sort]
+	if !ok {
+		order = imageSortOrders[models.ImageSortNewest]
+	}
+	query := `SELECT ` + imageFileColumns + ` FROM image_files` + where.String() + ` ORDER BY ` + order
+	args := where.args
+	if q.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, q.Limit, q.Offset)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query image files: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		img, err := scanImageFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan image file: %w", err)
+		}
+		page.Images = append(page.Images, img)
+	}
+	rows.Close()
+
+	if err := db.attachImageTags(page.Images); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetAllImageMimeTypes lists the file types of images outside the trash.
+func (db *DB) GetAllImageMimeTypes() ([]string, error) {
+	query := `SELECT DISTINCT mime_type FROM image_files WHERE deleted_at IS NULL ORDER BY mime_type`
+	return db.queryStrings(query)
+}
+
+// attachImageTags fills in the tags of images.
+func (db *DB) attachImageTags(images []*models.ImageFile) error {
+	if len(images) == 0 {
+		return nil
+	}
+	byID := make(map[int]*models.ImageFile, len(images))
+	ids := make([]int, len(images))
+	for i, img := range images {
+		byID[img.ID] = img
+		ids[i] = img.ID
+	}
+
+	clause, args := inClause("image_id", ids)
+	rows, err := db.conn.Query(`SELECT image_id, tag FROM image_tags WHERE `+clause+` ORDER BY tag`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to get image tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageID int
+		var tag string
+		if err := rows.Scan(&imageID, &tag); err != nil {
+			return fmt.Errorf("failed to scan image tag: %w", err)
+		}
+		if img := byID[imageID]; img != nil {
+			img.Tags = append(img.Tags, tag)
+		}
+	}
+	return nil
+}
+
+// GetAllImageTags lists the tags used by images outside the trash.
+func (db *DB) GetAllImageTags() ([]string, error) {
+	query := `SELECT DISTINCT t.tag FROM image_tags t JOIN image_files f ON f.id = t.image_id
+		WHERE f.deleted_at IS NULL ORDER BY t.tag`
+	return db.queryStrings(query)
+}
+
+// GetAllImageCollections lists the collections that hold images outside the trash.
+func (db *DB) GetAllImageCollections() ([]string, error) {
+	query := `SELECT DISTINCT collection FROM image_files WHERE collection != '' AND deleted_at IS NULL ORDER BY collection`
+	return db.queryStrings(query)
+}
+
+func (db *DB) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// BulkUpdateImageFiles applies update to the named images in a single
+// transaction and reports the outcome for each. Images that don't exist or
+// are in the trash are reported as not found without failing the batch. If
+// beforeCommit is non-nil it is called with the updated filenames just
+// before committing, and the whole batch is rolled back if it fails.
+func (db *DB) BulkUpdateImageFiles(filenames []string, update models.BulkImageUpdate, beforeCommit func(updated []string) error) ([]models.BulkImageResult, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]models.BulkImageResult, 0, len(filenames))
+	var updated []string
+	seen := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		if seen[filename] {
+			continue
+		}
+		seen[filename] = true
+
+		var id int
+		err := tx.QueryRow(`SELECT id FROM image_files WHERE filename = ? AND deleted_at IS NULL`, filename).Scan(&id)
+		if err == sql.ErrNoRows {
+			results = append(results, models.BulkImageResult{Filename: filename, Status: models.BulkResultNotFound})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image file: %w", err)
+		}
+
+		if err := updateImageFile(tx, id, update); err != nil {
+			return nil, err
+		}
+		results = append(results, models.BulkImageResult{Filename: filename, Status: models.BulkResultUpdated})
+		updated = append(updated, filename)
+	}
+
+	if beforeCommit != nil && len(updated) > 0 {
+		if err := beforeCommit(updated); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit bulk update: %w", err)
+	}
+	return results, nil
+}
+
+func updateImageFile(tx *sql.Tx, id int, update models.BulkImageUpdate) error {
+	if update.Enabled != nil {
+		if _, err := tx.Exec(`UPDATE image_files SET enabled = ? WHERE id = ?`, *update.Enabled, id); err != nil {
+			return fmt.Errorf("failed to update image enabled status: %w", err)
+		}
+	}
+	if update.Collection != nil {
+		if _, err := tx.Exec(`UPDATE image_files SET collection = ? WHERE id = ?`, *update.Collection, id); err != nil {
+			return fmt.Errorf("failed to update image collection: %w", err)
+		}
+	}
+	for _, tag := range update.AddTags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO image_tags (image_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return fmt.Errorf("failed to add image tag: %w", err)
+		}
+	}
+	for _, tag := range update.RemoveTags {
+		if _, err := tx.Exec(`DELETE FROM image_tags WHERE image_id = ? AND tag = ?`, id, tag); err != nil {
+			return fmt.Errorf("failed to remove image tag: %w", err)
+		}
+	}
+	if update.Trash {
+		if _, err := tx.Exec(`UPDATE image_files SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to trash image file: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetLibrarySize returns how many images are outside the trash and their
+// total size in bytes.
+func (db *DB) GetLibrarySize() (int, int64, error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM image_files WHERE deleted_at IS NULL`
+	var count int
+	var size int64
+	if err := db.conn.QueryRow(query).Scan(&count, &size); err != nil {
+		return 0, 0, fmt.Errorf("failed to get library size: %w", err)
+	}
+	return count, size, nil
+}
+
+func (db *DB) GetTotalImageFileCount() (int, error) {
+	query := `SELECT COUNT(*) FROM image_files WHERE deleted_at IS NULL`
+	var count int
+	err := db.conn.QueryRow(query).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total image file count: %w", err)
+	}
+	return count, nil
+}
+
+// Signing Secret methods
+func (db *DB) CreateSigningSecret(secret string) (*models.SigningSecret, error) {
+	query := `INSERT INTO signing_secrets (secret) VALUES (?)`
+	result, err := db.conn.Exec(query, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing secret: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	return &models.SigningSecret{
+		ID:        int(id),
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// GetActiveSigningSecrets returns unexpired signing secrets, newest first.
+func (db *DB) GetActiveSigningSecrets() ([]*models.SigningSecret, error) {
+	query := `SELECT id, secret, created_at, expires_at FROM signing_secrets
+		WHERE expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP
+		ORDER BY id DESC`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing secrets: %w", err)
+	}
+	defer rows.Close()
+
+	var secrets []*models.SigningSecret
+	for rows.Next() {
+		var secret models.SigningSecret
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&secret.ID, &secret.Secret, &secret.CreatedAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan signing secret: %w", err)
+		}
+		if expiresAt.Valid {
+			secret.ExpiresAt = &expiresAt.Time
+		}
+		secrets = append(secrets, &secret)
+	}
+
+	return secrets, nil
+}
+
+// ExpireSigningSecrets schedules every unexpired secret except keepID to expire at the given time.
+func (db *DB) ExpireSigningSecrets(keepID int, at time.Time) error {
+	query := `UPDATE signing_secrets SET expires_at = ? WHERE id != ? AND (expires_at IS NULL OR expires_at > ?)`
+	_, err := db.conn.Exec(query, formatTime(at), keepID, formatTime(at))
+	if err != nil {
+		return fmt.Errorf("failed to expire signing secrets: %w", err)
+	}
+	return nil
 }
 
 // Settings methods
@@ -478,6 +2112,13 @@ func (db *DB) InitializeDefaultSettings() error {
 		"max_image_count":           "100",
 		"cors_enabled":              "true",
 		"cors_origins":              "*",
+		"sign_image_urls":           "false",
+		"signed_url_ttl_seconds":    "3600",
+		"require_2fa":               "false",
+		"session_idle_timeout_minutes": "60",
+		"session_lifetime_hours":       "24",
+		"trash_retention_days":         "30",
+		"request_log_retention_days":   "90",
 	}
 
 	for key, value := range defaults {
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"shufflr/internal/models"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// seedImages adds images covering each ImageQuery filter:
+//
+//	a.png    100 bytes, Jan, tagged cats, in "pets", titled "Sunset 50%"
+//	b.jpg    300 bytes, Feb, tagged dogs, disabled, served 5 times
+//	c.png    200 bytes, Mar, tagged cats and dogs, scheduled
+//	d.gif    400 bytes, Apr, in the trash
+//	e_1.png   50 bytes, May, expired
+func seedImages(t *testing.T, db *DB) {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images := []struct {
+		filename string
+		size     int64
+		mimeType string
+		month    time.Month
+	}{
+		{"a.png", 100, "image/png", time.January},
+		{"b.jpg", 300, "image/jpeg", time.February},
+		{"c.png", 200, "image/png", time.March},
+		{"d.gif", 400, "image/gif", time.April},
+		{"e_1.png", 50, "image/png", time.May},
+	}
+	ids := make(map[string]int)
+	for _, img := range images {
+		created, err := db.CreateImageFile(img.filename, img.size, img.mimeType)
+		must(err)
+		ids[img.filename] = created.ID
+		uploadedAt := time.Date(2024, img.month, 1, 12, 0, 0, 0, time.UTC)
+		_, err = db.conn.Exec(`UPDATE image_files SET uploaded_at = ? WHERE id = ?`, formatTime(uploadedAt), created.ID)
+		must(err)
+	}
+
+	pets := "pets"
+	_, err := db.BulkUpdateImageFiles([]string{"a.png"}, models.BulkImageUpdate{AddTags: []string{"cats"}, Collection: &pets}, nil)
+	must(err)
+	_, err = db.BulkUpdateImageFiles([]string{"b.jpg"}, models.BulkImageUpdate{AddTags: []string{"dogs"}}, nil)
+	must(err)
+	_, err = db.BulkUpdateImageFiles([]string{"c.png"}, models.BulkImageUpdate{AddTags: []string{"cats", "dogs"}}, nil)
+	must(err)
+	must(db.UpdateImageMetadata("a.png", models.ImageMetadata{Title: "Sunset 50%"}))
+	must(db.UpdateImageEnabled("b.jpg", false))
+	must(db.AddImageServeCounts(map[int]models.ImageServeCounts{ids["b.jpg"]: {ServedCount: 5}}))
+
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-24 * time.Hour)
+	must(db.UpdateImageSchedule("c.png", &future, nil))
+	must(db.UpdateImageSchedule("e_1.png", nil, &past))
+
+	_, err = db.TrashImageFile("d.gif")
+	must(err)
+}
+
+func TestQueryImageFiles(t *testing.T) {
+	db := newTestDB(t)
+	seedImages(t, db)
+
+	enabled, disabled := true, false
+	tests := []struct {
+		name      string
+		query     models.ImageQuery
+		want      []string
+		wantTotal int
+	}{
+		{"all outside the trash, newest first", models.ImageQuery{}, []string{"e_1.png", "c.png", "b.jpg", "a.png"}, 4},
+		{"search filename", models.ImageQuery{Search: "b.j"}, []string{"b.jpg"}, 1},
+		{"search title case-insensitively", models.ImageQuery{Search: "SUNSET"}, []string{"a.png"}, 1},
+		{"search escapes %", models.ImageQuery{Search: "50%"}, []string{"a.png"}, 1},
+		{"search escapes _", models.ImageQuery{Search: "_"}, []string{"e_1.png"}, 1},
+		{"search excludes trash", models.ImageQuery{Search: "d.gif"}, nil, 0},
+		{"enabled", models.ImageQuery{Enabled: &enabled}, []string{"e_1.png", "c.png", "a.png"}, 3},
+		{"disabled", models.ImageQuery{Enabled: &disabled}, []string{"b.jpg"}, 1},
+		{"never served", models.ImageQuery{NeverServed: true}, []string{"e_1.png", "c.png", "a.png"}, 3},
+		{"live", models.ImageQuery{PublishState: models.PublishStateLive}, []string{"b.jpg", "a.png"}, 2},
+		{"scheduled", models.ImageQuery{PublishState: models.PublishStateScheduled}, []string{"c.png"}, 1},
+		{"expired", models.ImageQuery{PublishState: models.PublishStateExpired}, []string{"e_1.png"}, 1},
+		{"mime type", models.ImageQuery{MimeType: "image/png"}, []string{"e_1.png", "c.png", "a.png"}, 3},
+		{"tag", models.ImageQuery{Tag: "cats"}, []string{"c.png", "a.png"}, 2},
+		{"collection", models.ImageQuery{Collection: "pets"}, []string{"a.png"}, 1},
+		{"uploaded between", models.ImageQuery{
+			UploadedAfter:  time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			UploadedBefore: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		}, []string{"c.png", "b.jpg"}, 2},
+		{"size range", models.ImageQuery{MinSize: 150, MaxSize: 300}, []string{"c.png", "b.jpg"}, 2},
+		{"combined filters", models.ImageQuery{Tag: "dogs", Enabled: &enabled}, []string{"c.png"}, 1},
+		{"oldest", models.ImageQuery{Sort: models.ImageSortOldest}, []string{"a.png", "b.jpg", "c.png", "e_1.png"}, 4},
+		{"name", models.ImageQuery{Sort: models.ImageSortName}, []string{"a.png", "b.jpg", "c.png", "e_1.png"}, 4},
+		{"name descending", models.ImageQuery{Sort: models.ImageSortNameDesc}, []string{"e_1.png", "c.png", "b.jpg", "a.png"}, 4},
+		{"largest", models.ImageQuery{Sort: models.ImageSortLargest}, []string{"b.jpg", "c.png", "a.png", "e_1.png"}, 4},
+		{"smallest", models.ImageQuery{Sort: models.ImageSortSmallest}, []string{"e_1.png", "a.png", "c.png", "b.jpg"}, 4},
+		{"most served", models.ImageQuery{Sort: models.ImageSortMostServed}, []string{"b.jpg", "e_1.png", "c.png", "a.png"}, 4},
+		{"unknown sort falls back to newest", models.ImageQuery{Sort: "bogus"}, []string{"e_1.png", "c.png", "b.jpg", "a.png"}, 4},
+		{"page", models.ImageQuery{Limit: 2, Offset: 1}, []string{"c.png", "b.jpg"}, 4},
+		{"page past the end", models.ImageQuery{Limit: 2, Offset: 4}, nil, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := db.QueryImageFiles(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, img := range page.Images {
+				got = append(got, img.Filename)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("images = %v, want %v", got, tt.want)
+			}
+			if page.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", page.Total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestQueryImageFilesTotalsAndTags(t *testing.T) {
+	db := newTestDB(t)
+	seedImages(t, db)
+
+	page, err := db.QueryImageFiles(models.ImageQuery{Sort: models.ImageSortName, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Totals cover every match, not just the page
+	if page.Total != 4 || page.TotalSize != 650 {
+		t.Errorf("total = %d, %d bytes; want 4, 650 bytes", page.Total, page.TotalSize)
+	}
+
+	page, err = db.QueryImageFiles(models.ImageQuery{Search: "c.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Images) != 1 || !reflect.DeepEqual(page.Images[0].Tags, []string{"cats", "dogs"}) {
+		t.Fatalf("c.png tags = %+v, want [cats dogs]", page.Images)
+	}
+}
+
+func TestCreateOIDCAdminUser(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.CreateOIDCAdminUser("alice", "subject-1", models.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.GetAdminUserByOIDCSubject("subject-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Username != "alice" || user.Role != models.RoleEditor || user.ProxyAuth {
+		t.Fatalf("linked user = %+v", user)
+	}
+
+	// A taken username fails without creating an unlinked account
+	if _, err := db.CreateOIDCAdminUser("alice", "subject-2", models.RoleEditor); err == nil {
+		t.Error("expected an error for a taken username")
+	}
+	if user, err := db.GetAdminUserByOIDCSubject("subject-2"); err != nil || user != nil {
+		t.Errorf("subject-2 linked to %+v, %v", user, err)
+	}
+}
+
+func TestCreateProxyAdminUser(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.CreateProxyAdminUser("bob", models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.GetAdminUserByUsername("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || !user.ProxyAuth || user.Role != models.RoleViewer || user.OIDCSubject != "" {
+		t.Fatalf("proxy user = %+v", user)
+	}
+}
+
+func TestCreateRestrictedAPIKey(t *testing.T) {
+	db := newTestDB(t)
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	want := &models.APIKey{
+		Name:               "restricted",
+		Scopes:             []string{models.ScopeImagesRead},
+		ExpiresAt:          &expiresAt,
+		AllowedOrigins:     []string{"https://example.com"},
+		AllowedReferrers:   []string{"https://example.com/*"},
+		AllowedIPs:         []string{"192.0.2.0/24"},
+		AllowedImageIDs:    []int{3, 5},
+		RateLimitPerSecond: 2.5,
+		RateLimitBurst:     10,
+		DailyImageQuota:    100,
+		MonthlyImageQuota:  1000,
+	}
+	created, rawKey, err := db.CreateRestrictedAPIKey(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetAPIKeyByKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != created.ID {
+		t.Fatalf("GetAPIKeyByKey = %+v, want ID %d", got, created.ID)
+	}
+	if !reflect.DeepEqual(got.Scopes, want.Scopes) ||
+		!reflect.DeepEqual(got.AllowedOrigins, want.AllowedOrigins) ||
+		!reflect.DeepEqual(got.AllowedReferrers, want.AllowedReferrers) ||
+		!reflect.DeepEqual(got.AllowedIPs, want.AllowedIPs) ||
+		!reflect.DeepEqual(got.AllowedImageIDs, want.AllowedImageIDs) {
+		t.Errorf("restrictions = %+v, want %+v", got, want)
+	}
+	if got.RateLimitPerSecond != 2.5 || got.RateLimitBurst != 10 || got.DailyImageQuota != 100 || got.MonthlyImageQuota != 1000 {
+		t.Errorf("limits = %v/%d/%d/%d", got.RateLimitPerSecond, got.RateLimitBurst, got.DailyImageQuota, got.MonthlyImageQuota)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expires_at = %v, want %v", got.ExpiresAt, expiresAt)
+	}
+}
@@ -0,0 +1,165 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 Appendix B test vectors,
+// "12345678901234567890", base32 encoded.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 Appendix B. The RFC
+// lists 8-digit codes; 6-digit codes are their last six digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if want := tt.rfc[len(tt.rfc)-Digits:]; code != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, code, want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	code, err := Code(strings.ToLower(rfcSecret), Counter(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Errorf("Code = %q, %v; want 287082", code, err)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want int64
+	}{
+		{0, 0},
+		{29, 0},
+		{30, 1},
+		{59, 1},
+		{1111111109, 37037036},
+	}
+	for _, tt := range tests {
+		if got := Counter(time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Counter(%d) = %d, want %d", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Counter(now)
+	codeAt := func(counter int64) string {
+		code, err := Code(rfcSecret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		lastCounter int64
+		wantCounter int64
+		wantOK      bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"previous step within skew", codeAt(current - 1), 0, current - 1, true},
+		{"next step within skew", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"spaces are ignored", " " + codeAt(current)[:3] + " " + codeAt(current)[3:] + " ", 0, current, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"too short", codeAt(current)[:5], 0, 0, false},
+		{"too long", codeAt(current) + "1", 0, 0, false},
+		{"empty", "", 0, 0, false},
+		// A code can't be used again once its step has been accepted
+		{"reused code", codeAt(current), current, 0, false},
+		{"code older than last accepted", codeAt(current - 1), current, 0, false},
+		{"newer code after reuse check", codeAt(current + 1), current, current + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, tt.code, now, tt.lastCounter)
+			if ok != tt.wantOK || counter != tt.wantCounter {
+				t.Errorf("Validate(%q) = %d, %v; want %d, %v", tt.code, counter, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("two generated secrets are equal")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Shufflr", "admin user", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Shufflr:admin%20user?algorithm=SHA1&digits=6&issuer=Shufflr&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("URI = %s, want %s", got, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || code != strings.ToLower(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+
+		if got := NormalizeRecoveryCode(" " + strings.ToUpper(code) + " "); got != strings.Replace(code, "-", "", 1) {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", code, got)
+		}
+	}
+}
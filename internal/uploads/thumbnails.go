@@ -0,0 +1,171 @@
+package uploads
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"shufflr/internal/models"
+	"shufflr/internal/storage"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailDir is the directory inside the upload directory that holds
+// thumbnails, shared by images in and out of the trash.
+const ThumbnailDir = ".thumbs"
+
+// ThumbnailSize is the longest side of a thumbnail in pixels. Smaller
+// images are not enlarged.
+const ThumbnailSize = 256
+
+const thumbnailQuality = 80
+
+// Larger images aren't decoded, to bound the memory a thumbnail takes
+const maxThumbnailSourcePixels = 50_000_000
+
+// thumbnailSlots limits how many thumbnails are created at once, as each
+// holds a decoded image in memory.
+var thumbnailSlots = make(chan struct{}, 2)
+
+// ThumbnailPath returns where the thumbnail of an image is stored.
+func ThumbnailPath(uploadDir, filename string) string {
+	return filepath.Join(uploadDir, ThumbnailDir, filename+".jpg")
+}
+
+// GenerateThumbnail creates the thumbnail of the image file at srcPath,
+// replacing any existing one. It waits while too many thumbnails are being
+// created already.
+func GenerateThumbnail(uploadDir, filename, srcPath string) error {
+	thumbnailSlots <- struct{}{}
+	defer func() { <-thumbnailSlots }()
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer src.Close()
+
+	config, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return fmt.Errorf("image is too large for a thumbnail")
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return fmt.Errorf("image has no pixels")
+	}
+	if width > ThumbnailSize || height > ThumbnailSize {
+		if width >= height {
+			width, height = ThumbnailSize, max(1, height*ThumbnailSize/width)
+		} else {
+			width, height = max(1, width*ThumbnailSize/height), ThumbnailSize
+		}
+	}
+
+	// JPEG has no transparency, so transparent areas become white
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(thumb, thumb.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
+
+	if err := os.MkdirAll(filepath.Join(uploadDir, ThumbnailDir), 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+
+	// Write to a temporary file so a half-written thumbnail is never served
+	dstPath := ThumbnailPath(uploadDir, filename)
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dstPath); err != nil {
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	return nil
+}
+
+// RenameThumbnail moves an image's thumbnail along with a rename of the image.
+func RenameThumbnail(uploadDir, oldFilename, newFilename string) error {
+	err := os.Rename(ThumbnailPath(uploadDir, oldFilename), ThumbnailPath(uploadDir, newFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rename thumbnail: %w", err)
+	}
+	return nil
+}
+
+func removeThumbnail(uploadDir, filename string) error {
+	err := os.Remove(ThumbnailPath(uploadDir, filename))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete thumbnail: %w", err)
+	}
+	return nil
+}
+
+// BackfillThumbnails creates the missing thumbnails of images in and out of
+// the trash, returning how many were created. Images that can't be decoded
+// are logged and skipped.
+func BackfillThumbnails(db *storage.DB, uploadDir string) (int, error) {
+	images, err := db.GetAllImageFiles()
+	if err != nil {
+		return 0, err
+	}
+	trashed, err := db.GetTrashedImageFiles(time.Time{})
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, img := range append(images, trashed...) {
+		if _, err := os.Stat(ThumbnailPath(uploadDir, img.Filename)); err == nil {
+			continue
+		}
+		if err := GenerateThumbnail(uploadDir, img.Filename, ImagePath(uploadDir, img)); err != nil {
+			log.Printf("Error creating thumbnail for %s: %v", img.Filename, err)
+			continue
+		}
+		created++
+	}
+	return created, nil
+}
+
+// ImagePath returns where an image's file is, depending on whether it is in
+// the trash.
+func ImagePath(uploadDir string, img *models.ImageFile) string {
+	if img.DeletedAt != nil {
+		return TrashPath(uploadDir, img.Filename)
+	}
+	return filepath.Join(uploadDir, img.Filename)
+}
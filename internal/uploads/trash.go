@@ -0,0 +1,178 @@
+package uploads
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shufflr/internal/models"
+	"shufflr/internal/storage"
+	"strconv"
+	"time"
+)
+
+// TrashDir is the directory inside the upload directory that holds the
+// files of deleted images until they are purged.
+const TrashDir = ".trash"
+
+const defaultTrashRetentionDays = 30
+
+// ErrFilenameTaken is returned when restoring an image whose filename is
+// now used by another file.
+var ErrFilenameTaken = errors.New("a file with this name already exists")
+
+// ErrNotInTrash is returned when restoring or purging an image that is not
+// in the trash.
+var ErrNotInTrash = errors.New("image is not in the trash")
+
+// TrashPath returns where an image's file is kept while it is in the trash.
+func TrashPath(uploadDir, filename string) string {
+	return filepath.Join(uploadDir, TrashDir, filename)
+}
+
+// Exists reports whether filename is taken by an image, including images in
+// the trash, whose names stay reserved until they are purged, or by one of
+// the directories kept in the upload directory.
+func Exists(uploadDir, filename string) bool {
+	if filename == TrashDir || filename == ThumbnailDir {
+		return true
+	}
+	for _, path := range []string{filepath.Join(uploadDir, filename), TrashPath(uploadDir, filename)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Trash moves an image to the trash. It returns false if there is no such
+// image outside the trash.
+func Trash(db *storage.DB, uploadDir, filename string) (bool, error) {
+	trashed, err := db.TrashImageFile(filename)
+	if err != nil || !trashed {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(uploadDir, TrashDir), 0755); err != nil {
+		db.RestoreImageFile(filename)
+		return false, fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	err = os.Rename(filepath.Join(uploadDir, filename), TrashPath(uploadDir, filename))
+	if err != nil && !os.IsNotExist(err) {
+		db.RestoreImageFile(filename)
+		return false, fmt.Errorf("failed to move file to trash: %w", err)
+	}
+
+	return true, nil
+}
+
+// BulkUpdate applies update to the named images in one transaction. When
+// update moves the images to the trash their files are moved too, and if any
+// file can't be moved none of the images are changed.
+func BulkUpdate(db *storage.DB, uploadDir string, filenames []string, update models.BulkImageUpdate) ([]models.BulkImageResult, error) {
+	if !update.Trash {
+		return db.BulkUpdateImageFiles(filenames, update, nil)
+	}
+
+	return db.BulkUpdateImageFiles(filenames, update, func(updated []string) error {
+		if err := os.MkdirAll(filepath.Join(uploadDir, TrashDir), 0755); err != nil {
+			return fmt.Errorf("failed to create trash directory: %w", err)
+		}
+		for i, filename := range updated {
+			err := os.Rename(filepath.Join(uploadDir, filename), TrashPath(uploadDir, filename))
+			if err != nil && !os.IsNotExist(err) {
+				// Put back the files already moved before the records are rolled back
+				for _, moved := range updated[:i] {
+					os.Rename(TrashPath(uploadDir, moved), filepath.Join(uploadDir, moved))
+				}
+				return fmt.Errorf("failed to move %s to trash: %w", filename, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Restore takes an image out of the trash.
+func Restore(db *storage.DB, uploadDir, filename string) error {
+	if err := checkInTrash(db, filename); err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(uploadDir, filename)
+	if _, err := os.Stat(filePath); err == nil {
+		return ErrFilenameTaken
+	}
+
+	if err := os.Rename(TrashPath(uploadDir, filename), filePath); err != nil {
+		return fmt.Errorf("failed to move file out of trash: %w", err)
+	}
+	if err := db.RestoreImageFile(filename); err != nil {
+		os.Rename(filePath, TrashPath(uploadDir, filename))
+		return err
+	}
+
+	return nil
+}
+
+// Purge permanently deletes an image in the trash.
+func Purge(db *storage.DB, uploadDir, filename string) error {
+	if err := checkInTrash(db, filename); err != nil {
+		return err
+	}
+
+	if err := db.DeleteImageFile(filename); err != nil {
+		return err
+	}
+	if err := os.Remove(TrashPath(uploadDir, filename)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return removeThumbnail(uploadDir, filename)
+}
+
+func checkInTrash(db *storage.DB, filename string) error {
+	img, err := db.GetTrashedImageFile(filename)
+	if err != nil {
+		return err
+	}
+	if img == nil {
+		return ErrNotInTrash
+	}
+	return nil
+}
+
+// TrashRetentionDays returns how long deleted images stay in the trash
+// before they are purged automatically. 0 keeps them until purged by hand.
+func TrashRetentionDays(db *storage.DB) int {
+	value, err := db.GetSetting("trash_retention_days")
+	if err != nil {
+		return defaultTrashRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return defaultTrashRetentionDays
+	}
+	return days
+}
+
+// PurgeExpired permanently deletes images that have been in the trash for
+// longer than the retention period, returning how many were deleted.
+func PurgeExpired(db *storage.DB, uploadDir string) (int, error) {
+	days := TrashRetentionDays(db)
+	if days == 0 {
+		return 0, nil
+	}
+
+	images, err := db.GetTrashedImageFiles(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, img := range images {
+		if err := Purge(db, uploadDir, img.Filename); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
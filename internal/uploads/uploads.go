@@ -0,0 +1,110 @@
+package uploads
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"shufflr/internal/metrics"
+	"shufflr/internal/models"
+	"shufflr/internal/storage"
+	"strings"
+)
+
+var uploadsTotal = metrics.NewCounter("shufflr_uploads_total",
+	"Image uploads through the admin interface and the API, by result.", "result")
+
+// Save writes an uploaded image into uploadDir and records it in the database.
+// If a file with the same name already exists, a numeric suffix is appended.
+func Save(db *storage.DB, uploadDir string, fileHeader *multipart.FileHeader) (*models.ImageFile, error) {
+	image, err := save(db, uploadDir, fileHeader)
+	if err != nil {
+		uploadsTotal.Inc("failure")
+		return nil, err
+	}
+	uploadsTotal.Inc("success")
+	return image, nil
+}
+
+func save(db *storage.DB, uploadDir string, fileHeader *multipart.FileHeader) (*models.ImageFile, error) {
+	// Validate file type
+	if !IsValidImageType(fileHeader.Header.Get("Content-Type")) {
+		return nil, fmt.Errorf("invalid file type")
+	}
+
+	// Open uploaded file
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer file.Close()
+
+	// Create unique filename if file already exists
+	filename := filepath.Base(fileHeader.Filename)
+	filePath := filepath.Join(uploadDir, filename)
+	counter := 1
+	for {
+		if !Exists(uploadDir, filename) {
+			break
+		}
+		// File exists, create new name
+		ext := filepath.Ext(fileHeader.Filename)
+		name := strings.TrimSuffix(filepath.Base(fileHeader.Filename), ext)
+		filename = fmt.Sprintf("%s_%d%s", name, counter, ext)
+		filePath = filepath.Join(uploadDir, filename)
+		counter++
+	}
+
+	// Create destination file
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dst.Close()
+
+	// Copy file data
+	if _, err := io.Copy(dst, file); err != nil {
+		os.Remove(filePath) // Clean up on error
+		return nil, fmt.Errorf("failed to copy file data: %w", err)
+	}
+
+	// Get file info
+	fileInfo, err := dst.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	// Save to database
+	image, err := db.CreateImageFile(filename, fileInfo.Size(), fileHeader.Header.Get("Content-Type"))
+	if err != nil {
+		os.Remove(filePath) // Clean up on error
+		return nil, fmt.Errorf("failed to save to database: %w", err)
+	}
+
+	// If this fails the admin interface tries again when the thumbnail is
+	// first requested, and shows a placeholder if that fails too
+	if err := GenerateThumbnail(uploadDir, filename, filePath); err != nil {
+		log.Printf("Error creating thumbnail for %s: %v", filename, err)
+	}
+
+	return image, nil
+}
+
+func IsValidImageType(contentType string) bool {
+	validTypes := []string{
+		"image/jpeg",
+		"image/jpg",
+		"image/png",
+		"image/gif",
+		"image/webp",
+	}
+
+	for _, validType := range validTypes {
+		if contentType == validType {
+			return true
+		}
+	}
+	return false
+}